@@ -1,27 +1,164 @@
 package config
 
 import (
+	"errors"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// DefaultJWTSecret is the development fallback for JWT_SECRET. Tokens signed
+// with it are trivially forgeable, so production refuses to start with it.
+const DefaultJWTSecret = "your-secret-key"
+
 type Config struct {
 	Port              string
 	JWTSecret         string
+	JWTSigningKeyFile string
+	JWTVerifyKeyFiles []string
 	SendGridAPIKey    string
 	SendGridFromEmail string
 	FrontendURL       string
 	GO_ENV            string
+	CookieSecure      bool
+	CookieDomain      string
+	AccessTokenTTL    time.Duration
+	RefreshTokenTTL   time.Duration
+	ImpersonationTTL  time.Duration
+	PasswordPolicy    PasswordPolicy
+	PasswordHashing   PasswordHashing
+	MFAIssuer         string
+	WebAuthnRPID      string
+	WebAuthnRPName    string
+	WebAuthnOrigins   []string
+	MagicLinkEnabled  bool
+	MagicLinkTTL      time.Duration
+	OIDCRedirectBase  string
+	OIDCProviders     []OIDCProvider
+	// BlogNotifyEmails receive SendBlogNotification when a post is
+	// published; when empty the post's owner is notified instead
+	BlogNotifyEmails  []string
+	BlogSchedulerTick time.Duration
+}
+
+// OIDCProvider is an OpenID Connect identity provider users can sign in with.
+// Each name listed in OIDC_PROVIDERS is read from OIDC_<NAME>_* variables.
+type OIDCProvider struct {
+	Name         string
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+// PasswordPolicy controls which passwords are accepted at signup and reset.
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+}
+
+// PasswordHashing holds the argon2id parameters for new password hashes.
+// Stored hashes made with other parameters are upgraded at the next login.
+type PasswordHashing struct {
+	MemoryKiB             int
+	Iterations            int
+	Parallelism           int
+	BreachedPasswordsFile string
 }
 
 func Load() *Config {
+	frontendURL := getEnv("FRONTEND_URL", "http://localhost:3001")
+	port := getEnv("PORT", "3003")
+	goEnv := getEnv("GO_ENV", "development")
 	return &Config{
-		Port:              getEnv("PORT", "3003"),
-		JWTSecret:         getEnv("JWT_SECRET", "your-secret-key"),
+		Port:              port,
+		JWTSecret:         getEnv("JWT_SECRET", DefaultJWTSecret),
+		JWTSigningKeyFile: getEnv("JWT_SIGNING_KEY_FILE", ""),
+		JWTVerifyKeyFiles: getEnvList("JWT_VERIFY_KEY_FILES", nil),
 		SendGridAPIKey:    getEnv("SENDGRID_API_KEY", ""),
 		SendGridFromEmail: getEnv("SENDGRID_FROM_EMAIL", ""),
-		FrontendURL:       getEnv("FRONTEND_URL", "http://localhost:3001"),
-		GO_ENV:            getEnv("GO_ENV", "development"),
+		FrontendURL:       frontendURL,
+		GO_ENV:            goEnv,
+		CookieSecure:      getEnvBool("COOKIE_SECURE", goEnv == "production"),
+		CookieDomain:      getEnv("COOKIE_DOMAIN", ""),
+		AccessTokenTTL:    getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:   getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		ImpersonationTTL:  getEnvDuration("IMPERSONATION_TTL", 15*time.Minute),
+		MFAIssuer:         getEnv("MFA_ISSUER", "Ed and Linda"),
+		WebAuthnRPID:      getEnv("WEBAUTHN_RP_ID", hostOf(frontendURL)),
+		WebAuthnRPName:    getEnv("WEBAUTHN_RP_NAME", "Ed and Linda"),
+		WebAuthnOrigins:   getEnvList("WEBAUTHN_ORIGINS", []string{frontendURL}),
+		MagicLinkEnabled:  getEnvBool("MAGIC_LINK_ENABLED", true),
+		MagicLinkTTL:      getEnvDuration("MAGIC_LINK_TTL", 15*time.Minute),
+		OIDCRedirectBase:  strings.TrimSuffix(getEnv("OIDC_REDIRECT_BASE_URL", "http://localhost:"+port), "/"),
+		BlogNotifyEmails:  getEnvList("BLOG_NOTIFY_EMAILS", nil),
+		BlogSchedulerTick: getEnvDuration("BLOG_SCHEDULER_TICK", time.Minute),
+		OIDCProviders:     loadOIDCProviders(),
+		PasswordPolicy: PasswordPolicy{
+			MinLength:     getEnvInt("PASSWORD_MIN_LENGTH", 10),
+			RequireUpper:  getEnvBool("PASSWORD_REQUIRE_UPPER", false),
+			RequireLower:  getEnvBool("PASSWORD_REQUIRE_LOWER", false),
+			RequireDigit:  getEnvBool("PASSWORD_REQUIRE_DIGIT", true),
+			RequireSymbol: getEnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+		},
+		PasswordHashing: PasswordHashing{
+			MemoryKiB:             getEnvInt("ARGON2_MEMORY_KIB", 64*1024),
+			Iterations:            getEnvInt("ARGON2_ITERATIONS", 3),
+			Parallelism:           getEnvInt("ARGON2_PARALLELISM", 2),
+			BreachedPasswordsFile: getEnv("BREACHED_PASSWORDS_FILE", ""),
+		},
+	}
+}
+
+// Validate rejects configurations that are unsafe to run in production
+func (c *Config) Validate() error {
+	if c.GO_ENV != "production" {
+		return nil
 	}
+	if c.JWTSigningKeyFile == "" && c.JWTSecret == DefaultJWTSecret {
+		return errors.New("JWT_SECRET must be set (or JWT_SIGNING_KEY_FILE configured) when GO_ENV is production")
+	}
+	return nil
+}
+
+// OIDCProvider returns the configured provider with the given name
+func (c *Config) OIDCProvider(name string) (OIDCProvider, bool) {
+	for _, p := range c.OIDCProviders {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return OIDCProvider{}, false
+}
+
+// loadOIDCProviders reads OIDC_PROVIDERS (e.g. "google,microsoft") and the
+// matching OIDC_GOOGLE_ISSUER, OIDC_GOOGLE_CLIENT_ID, ... variables. Providers
+// missing an issuer or client ID are skipped.
+func loadOIDCProviders() []OIDCProvider {
+	var providers []OIDCProvider
+	for _, name := range getEnvList("OIDC_PROVIDERS", nil) {
+		name = strings.ToLower(name)
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		p := OIDCProvider{
+			Name:         name,
+			DisplayName:  getEnv(prefix+"DISPLAY_NAME", name),
+			Issuer:       getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			Scopes:       getEnvList(prefix+"SCOPES", []string{"openid", "email", "profile"}),
+		}
+		if p.Issuer == "" || p.ClientID == "" {
+			continue
+		}
+		providers = append(providers, p)
+	}
+	return providers
 }
 
 func getEnv(key, defaultValue string) string {
@@ -30,3 +167,53 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvDuration parses values such as "15m" or "720h", falling back to the
+// default when the variable is unset or malformed.
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
+
+// getEnvList splits a comma-separated variable into trimmed, non-empty values
+func getEnvList(key string, defaultValue []string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return defaultValue
+	}
+	return values
+}
+
+func hostOf(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return "localhost"
+}
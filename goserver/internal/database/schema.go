@@ -0,0 +1,233 @@
+package database
+
+import (
+	"fmt"
+	"log"
+)
+
+// schemaStatements holds the DDL for tables added after the original dump.
+// Every statement must be idempotent because it runs on each startup.
+var schemaStatements = []string{
+	`CREATE TABLE IF NOT EXISTS refresh_tokens (
+        id SERIAL PRIMARY KEY,
+        user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        family_id character varying(64) NOT NULL,
+        token_hash character varying(64) NOT NULL UNIQUE,
+        device_id character varying(255) NOT NULL DEFAULT '',
+        user_agent text NOT NULL DEFAULT '',
+        ip_address character varying(64) NOT NULL DEFAULT '',
+        expires_at timestamp without time zone NOT NULL,
+        rotated_at timestamp without time zone,
+        revoked_at timestamp without time zone,
+        created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+    )`,
+	`CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON refresh_tokens (family_id)`,
+	`CREATE INDEX IF NOT EXISTS refresh_tokens_user_idx ON refresh_tokens (user_id)`,
+	`CREATE TABLE IF NOT EXISTS revoked_tokens (
+        jti character varying(64) PRIMARY KEY,
+        user_id integer NOT NULL,
+        expires_at timestamp without time zone NOT NULL,
+        revoked_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+    )`,
+	`CREATE TABLE IF NOT EXISTS user_token_revocations (
+        user_id integer PRIMARY KEY,
+        revoked_before timestamp without time zone NOT NULL
+    )`,
+	`CREATE TABLE IF NOT EXISTS password_reset_tokens (
+        id SERIAL PRIMARY KEY,
+        user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        token_hash character varying(64) NOT NULL UNIQUE,
+        expires_at timestamp without time zone NOT NULL,
+        used_at timestamp without time zone,
+        created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+    )`,
+	`CREATE TABLE IF NOT EXISTS user_mfa (
+        user_id integer PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+        totp_secret character varying(64) NOT NULL,
+        enabled boolean NOT NULL DEFAULT false,
+        last_used_step bigint NOT NULL DEFAULT 0,
+        confirmed_at timestamp without time zone,
+        created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+    )`,
+	`CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+        id SERIAL PRIMARY KEY,
+        user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        code_hash character varying(64) NOT NULL,
+        used_at timestamp without time zone,
+        created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+    )`,
+	`CREATE TABLE IF NOT EXISTS webauthn_credentials (
+        id SERIAL PRIMARY KEY,
+        user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        credential_id character varying(1024) NOT NULL UNIQUE,
+        credential_name character varying(255) NOT NULL DEFAULT '',
+        credential_data text NOT NULL,
+        last_used_at timestamp without time zone,
+        created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+    )`,
+	`CREATE INDEX IF NOT EXISTS webauthn_credentials_user_idx ON webauthn_credentials (user_id)`,
+	`CREATE TABLE IF NOT EXISTS login_attempts (
+        id SERIAL PRIMARY KEY,
+        user_name character varying(255) NOT NULL,
+        ip_address character varying(64) NOT NULL,
+        success boolean NOT NULL,
+        reason character varying(64) NOT NULL,
+        created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+    )`,
+	`CREATE INDEX IF NOT EXISTS login_attempts_created_idx ON login_attempts (created_at)`,
+	`CREATE TABLE IF NOT EXISTS login_throttles (
+        throttle_key character varying(320) PRIMARY KEY,
+        failed_count integer NOT NULL DEFAULT 0,
+        locked_until timestamp without time zone,
+        last_failed_at timestamp without time zone NOT NULL
+    )`,
+	`CREATE TABLE IF NOT EXISTS user_identities (
+        id SERIAL PRIMARY KEY,
+        user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        provider character varying(64) NOT NULL,
+        subject character varying(255) NOT NULL,
+        email character varying(255) NOT NULL DEFAULT '',
+        last_login_at timestamp without time zone,
+        created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE (provider, subject)
+    )`,
+	`CREATE INDEX IF NOT EXISTS user_identities_user_idx ON user_identities (user_id)`,
+	`CREATE TABLE IF NOT EXISTS personal_access_tokens (
+        id SERIAL PRIMARY KEY,
+        user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        token_name character varying(100) NOT NULL,
+        token_prefix character varying(32) NOT NULL,
+        token_hash character varying(64) NOT NULL UNIQUE,
+        scopes text[] NOT NULL,
+        expires_at timestamp without time zone NOT NULL,
+        last_used_at timestamp without time zone,
+        revoked_at timestamp without time zone,
+        created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+    )`,
+	`CREATE INDEX IF NOT EXISTS personal_access_tokens_user_idx ON personal_access_tokens (user_id)`,
+	`CREATE TABLE IF NOT EXISTS user_sessions (
+        family_id character varying(64) PRIMARY KEY,
+        user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        device_id character varying(255) NOT NULL DEFAULT '',
+        user_agent text NOT NULL DEFAULT '',
+        ip_address character varying(64) NOT NULL DEFAULT '',
+        expires_at timestamp without time zone NOT NULL,
+        last_used_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP,
+        revoked_at timestamp without time zone,
+        created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+    )`,
+	`CREATE INDEX IF NOT EXISTS user_sessions_user_idx ON user_sessions (user_id)`,
+	`CREATE TABLE IF NOT EXISTS roles (
+        id SERIAL PRIMARY KEY,
+        role_name character varying(50) NOT NULL UNIQUE,
+        role_level integer NOT NULL DEFAULT 1,
+        require_mfa boolean NOT NULL DEFAULT false,
+        description text NOT NULL DEFAULT '',
+        created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP,
+        updated_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+    )`,
+	`CREATE TABLE IF NOT EXISTS permissions (
+        permission_name character varying(64) PRIMARY KEY,
+        description text NOT NULL DEFAULT ''
+    )`,
+	`CREATE TABLE IF NOT EXISTS role_permissions (
+        role_id integer NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+        permission_name character varying(64) NOT NULL REFERENCES permissions(permission_name) ON DELETE CASCADE,
+        PRIMARY KEY (role_id, permission_name)
+    )`,
+	`CREATE TABLE IF NOT EXISTS role_requests (
+        id SERIAL PRIMARY KEY,
+        user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        requested_role character varying(50) NOT NULL,
+        note text NOT NULL DEFAULT '',
+        status character varying(16) NOT NULL DEFAULT 'pending',
+        reviewer_id integer REFERENCES users(id) ON DELETE SET NULL,
+        review_note text NOT NULL DEFAULT '',
+        reviewed_at timestamp without time zone,
+        created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+    )`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS role_requests_pending_idx ON role_requests (user_id) WHERE status = 'pending'`,
+	`CREATE TABLE IF NOT EXISTS magic_links (
+        id SERIAL PRIMARY KEY,
+        user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        token_hash character varying(64) NOT NULL UNIQUE,
+        nonce_hash character varying(64) NOT NULL,
+        expires_at timestamp without time zone NOT NULL,
+        used_at timestamp without time zone,
+        created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+    )`,
+	`CREATE TABLE IF NOT EXISTS email_changes (
+        id SERIAL PRIMARY KEY,
+        user_id integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+        new_email character varying(255) NOT NULL,
+        code_hash character varying(64) NOT NULL UNIQUE,
+        cancel_hash character varying(64) NOT NULL UNIQUE,
+        expires_at timestamp without time zone NOT NULL,
+        confirmed_at timestamp without time zone,
+        cancelled_at timestamp without time zone,
+        created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+    )`,
+	`CREATE TABLE IF NOT EXISTS invitations (
+        id SERIAL PRIMARY KEY,
+        email character varying(255) NOT NULL,
+        role character varying(50) NOT NULL,
+        token_hash character varying(64) NOT NULL UNIQUE,
+        invited_by integer REFERENCES users(id) ON DELETE SET NULL,
+        expires_at timestamp without time zone NOT NULL,
+        accepted_at timestamp without time zone,
+        accepted_user_id integer REFERENCES users(id) ON DELETE SET NULL,
+        revoked_at timestamp without time zone,
+        created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+    )`,
+	`CREATE TABLE IF NOT EXISTS audit_log (
+        id SERIAL PRIMARY KEY,
+        actor_id integer REFERENCES users(id) ON DELETE SET NULL,
+        subject_user_id integer REFERENCES users(id) ON DELETE SET NULL,
+        action character varying(64) NOT NULL,
+        method character varying(10) NOT NULL DEFAULT '',
+        path text NOT NULL DEFAULT '',
+        status integer NOT NULL DEFAULT 0,
+        ip_address character varying(64) NOT NULL DEFAULT '',
+        session_id character varying(64) NOT NULL DEFAULT '',
+        details text NOT NULL DEFAULT '',
+        created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP
+    )`,
+	`CREATE INDEX IF NOT EXISTS audit_log_created_idx ON audit_log (created_at DESC)`,
+	`CREATE TABLE IF NOT EXISTS blog_revisions (
+        id SERIAL PRIMARY KEY,
+        blog_id integer NOT NULL REFERENCES blogs(id) ON DELETE CASCADE,
+        revision_number integer NOT NULL,
+        blog_subject character varying(255) NOT NULL,
+        blog_body text NOT NULL,
+        blog_category character varying(255) NOT NULL,
+        author_id integer REFERENCES users(id) ON DELETE SET NULL,
+        author_name character varying(255) NOT NULL,
+        restored_from integer,
+        created_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP,
+        UNIQUE (blog_id, revision_number)
+    )`,
+	// Posts from before publishing states are published and already
+	// announced; the notified_at default is then dropped for new posts
+	`ALTER TABLE blogs ADD COLUMN IF NOT EXISTS status character varying(16) NOT NULL DEFAULT 'published'
+        CHECK (status IN ('draft', 'scheduled', 'published', 'archived'))`,
+	`ALTER TABLE blogs ALTER COLUMN status SET DEFAULT 'published'`,
+	// publish_at is an instant chosen by the client, so it keeps its zone;
+	// the ALTER converts a column created without one and is a no-op after
+	`ALTER TABLE blogs ADD COLUMN IF NOT EXISTS publish_at timestamp with time zone`,
+	`ALTER TABLE blogs ALTER COLUMN publish_at TYPE timestamp with time zone`,
+	`ALTER TABLE blogs ADD COLUMN IF NOT EXISTS notified_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP`,
+	`ALTER TABLE blogs ALTER COLUMN notified_at DROP DEFAULT`,
+	`CREATE INDEX IF NOT EXISTS blogs_scheduled_idx ON blogs (publish_at) WHERE status = 'scheduled'`,
+}
+
+// EnsureSchema creates any missing tables and indexes used by the server.
+func EnsureSchema() error {
+	for _, stmt := range schemaStatements {
+		if _, err := DB.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to apply schema: %v", err)
+		}
+	}
+
+	log.Println("Database schema is up to date")
+	return nil
+}
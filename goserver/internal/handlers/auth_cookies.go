@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+
+	"goserver/internal/config"
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// refreshCookiePath limits the refresh token cookie to the auth routes
+const refreshCookiePath = "/api/v1/auth"
+
+// wantsCookieAuth reports whether the client asked for cookie mode
+func wantsCookieAuth(c *gin.Context) bool {
+	return c.GetHeader(services.AuthModeHeader) == services.AuthModeCookie
+}
+
+// respondWithTokens sends a freshly issued token pair, merged into body. In
+// cookie mode the tokens are set as HttpOnly cookies and only the CSRF token
+// the frontend must echo back is returned.
+func respondWithTokens(c *gin.Context, tokens *services.TokenPair, body gin.H, cookieMode bool) {
+	if body == nil {
+		body = gin.H{}
+	}
+	body["expiresIn"] = tokens.ExpiresIn
+
+	if !cookieMode {
+		body["accessToken"] = tokens.AccessToken
+		body["refreshToken"] = tokens.RefreshToken
+		c.JSON(http.StatusOK, body)
+		return
+	}
+
+	csrfToken, err := services.NewCSRFToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not generate token"})
+		return
+	}
+
+	cfg := config.Load()
+	refreshMaxAge := int(cfg.RefreshTokenTTL.Seconds())
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(services.AccessTokenCookie, tokens.AccessToken, int(tokens.ExpiresIn), "/", cfg.CookieDomain, cfg.CookieSecure, true)
+	c.SetCookie(services.RefreshTokenCookie, tokens.RefreshToken, refreshMaxAge, refreshCookiePath, cfg.CookieDomain, cfg.CookieSecure, true)
+	c.SetCookie(services.CSRFCookie, csrfToken, refreshMaxAge, "/", cfg.CookieDomain, cfg.CookieSecure, false)
+
+	body["csrfToken"] = csrfToken
+	c.JSON(http.StatusOK, body)
+}
+
+// respondWithLogin sends the outcome of CompleteLogin: tokens, or the MFA
+// challenge that has to be answered first.
+func respondWithLogin(c *gin.Context, result *services.LoginResult) {
+	if result.Tokens != nil {
+		respondWithTokens(c, result.Tokens, nil, wantsCookieAuth(c))
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// clearAuthCookies removes the cookie-mode session from the browser
+func clearAuthCookies(c *gin.Context) {
+	cfg := config.Load()
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(services.AccessTokenCookie, "", -1, "/", cfg.CookieDomain, cfg.CookieSecure, true)
+	c.SetCookie(services.RefreshTokenCookie, "", -1, refreshCookiePath, cfg.CookieDomain, cfg.CookieSecure, true)
+	c.SetCookie(services.CSRFCookie, "", -1, "/", cfg.CookieDomain, cfg.CookieSecure, false)
+}
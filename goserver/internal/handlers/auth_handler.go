@@ -1,8 +1,15 @@
 package handlers
 
 import (
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"goserver/internal/config"
+	"goserver/internal/models"
 	"goserver/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -18,43 +25,329 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	var loginData struct {
 		Name     string `json:"user_name"`
 		Password string `json:"user_password"`
+		DeviceID string `json:"device_id"`
 	}
 	if err := c.ShouldBindJSON(&loginData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request", "error": err.Error()})
 		return
 	}
 
-	user, validationErrors, _ := services.LoginUser(loginData.Name, loginData.Password)
+	user, validationErrors, err := services.LoginUser(loginData.Name, loginData.Password, c.ClientIP())
+	var throttled *services.LoginThrottledError
+	if errors.As(err, &throttled) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"message": throttled.Error()})
+		return
+	}
+	if err != nil && !errors.Is(err, services.ErrInvalidCredentials) && !errors.Is(err, services.ErrEmailNotVerified) {
+		log.Printf("Login failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not log in"})
+		return
+	}
 	if len(validationErrors) > 0 || user == nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid credentials"})
 		return
 	}
 
-	accessToken, err := services.GenerateAccessToken(user)
+	result, err := services.CompleteLogin(user, clientInfo(c, loginData.DeviceID))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not generate token"})
 		return
 	}
-
-	c.JSON(http.StatusOK, gin.H{"accessToken": accessToken})
+	respondWithLogin(c, result)
 }
 
 func (h *AuthHandler) Signup(c *gin.Context) {
-	// TODO: Register user, send verification email
-	c.JSON(http.StatusOK, gin.H{"message": "Signup endpoint"})
+	var req models.SignupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request", "error": err.Error()})
+		return
+	}
+
+	user, validationErrors, err := services.SignupUser(&req)
+	if len(validationErrors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": validationErrors})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not create account"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Account created. Please check your email to verify your address.",
+		"user": gin.H{
+			"id":         user.ID,
+			"user_name":  user.Username,
+			"user_email": user.Email,
+			"role":       user.Role,
+		},
+	})
 }
 
+// Logout revokes the access token and the refresh token's family. Either one
+// is enough: the route has no auth middleware, so a client whose access
+// token has expired can still revoke its refresh token. When both are sent
+// they must belong to the same user.
 func (h *AuthHandler) Logout(c *gin.Context) {
-	// Nothing to do here. Token gets deleted on client-side.
-	c.JSON(http.StatusOK, gin.H{"message": "Logout endpoint"})
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	_ = c.ShouldBindJSON(&req)
+
+	usesCookies := false
+	accessToken, hasBearer := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !hasBearer {
+		accessToken, _ = c.Cookie(services.AccessTokenCookie)
+		usesCookies = accessToken != ""
+	}
+	if req.RefreshToken == "" {
+		req.RefreshToken, _ = c.Cookie(services.RefreshTokenCookie)
+		usesCookies = usesCookies || req.RefreshToken != ""
+	}
+	if accessToken == "" && req.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Access or refresh token is required"})
+		return
+	}
+	if usesCookies {
+		csrfCookie, _ := c.Cookie(services.CSRFCookie)
+		if !services.ValidCSRFToken(csrfCookie, c.GetHeader(services.CSRFHeader)) {
+			c.JSON(http.StatusForbidden, gin.H{"message": "Missing or invalid CSRF token"})
+			return
+		}
+	}
+
+	// An expired or invalid access token has nothing left to revoke
+	userID := 0
+	if claims, err := services.ParseAccessToken(accessToken); err == nil {
+		// The refresh cookie during impersonation is the admin's own
+		if _, ok := services.ImpersonatorID(claims); ok {
+			c.JSON(http.StatusForbidden, gin.H{"message": "End the impersonation instead of logging out"})
+			return
+		}
+		jti, _ := claims["jti"].(string)
+		id, _ := claims["user"].(float64)
+		userID = int(id)
+		if err := services.RevokeToken(jti, userID, services.ClaimTime(claims, "exp")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not log out"})
+			return
+		}
+	}
+
+	clearAuthCookies(c)
+
+	if err := services.RevokeRefreshTokenFamily(req.RefreshToken, userID); err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) {
+			c.JSON(http.StatusForbidden, gin.H{"message": "Refresh token belongs to a different user"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
 
 func (h *AuthHandler) RefreshToken(c *gin.Context) {
-	// TODO: Issue new JWT if refresh token is valid
-	c.JSON(http.StatusOK, gin.H{"message": "Refresh token endpoint"})
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+		DeviceID     string `json:"device_id"`
+	}
+	_ = c.ShouldBindJSON(&req)
+
+	// In cookie mode the refresh token comes from its cookie, so the request
+	// must also carry the CSRF token.
+	cookieMode := false
+	if req.RefreshToken == "" {
+		req.RefreshToken, _ = c.Cookie(services.RefreshTokenCookie)
+		if req.RefreshToken != "" {
+			csrfCookie, _ := c.Cookie(services.CSRFCookie)
+			if !services.ValidCSRFToken(csrfCookie, c.GetHeader(services.CSRFHeader)) {
+				c.JSON(http.StatusForbidden, gin.H{"message": "Missing or invalid CSRF token"})
+				return
+			}
+			cookieMode = true
+		}
+	}
+	if req.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Refresh token is required"})
+		return
+	}
+
+	tokens, err := services.RotateRefreshToken(req.RefreshToken, clientInfo(c, req.DeviceID))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not refresh token"})
+		return
+	}
+
+	respondWithTokens(c, tokens, nil, cookieMode || wantsCookieAuth(c))
+}
+
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req struct {
+		Email string `json:"user_email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Email is required"})
+		return
+	}
+
+	// Always answer the same way so the endpoint can't be used to find accounts
+	if wait := services.RequestPasswordReset(req.Email, c.ClientIP()); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"message": "Please wait before requesting another password reset."})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If an account exists for that email, a password reset link has been sent."})
+}
+
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"user_password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Reset token is required"})
+		return
+	}
+
+	validationErrors, err := services.ResetPassword(req.Token, req.Password)
+	if len(validationErrors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid password", "errors": validationErrors})
+		return
+	}
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidResetToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not reset password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset. Please log in with your new password."})
 }
 
 func (h *AuthHandler) ResendVerificationEmail(c *gin.Context) {
-	// TODO: Resend verification email
-	c.JSON(http.StatusOK, gin.H{"message": "Resend verification endpoint"})
+	var req struct {
+		Email string `json:"user_email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Email is required"})
+		return
+	}
+
+	wait, err := services.ResendVerificationEmail(req.Email, c.ClientIP())
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"message": "Please wait before requesting another verification email."})
+		return
+	}
+	if err != nil {
+		log.Printf("Resend verification failed: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If that account is awaiting verification, a new verification email has been sent."})
+}
+
+// magicLinkNonceCookie holds the nonce that binds an emailed sign-in link to
+// the browser that requested it.
+const magicLinkNonceCookie = "magic_link_nonce"
+
+// POST /api/v1/auth/magic-link
+func (h *AuthHandler) RequestMagicLink(c *gin.Context) {
+	cfg := config.Load()
+	if !cfg.MagicLinkEnabled {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Email sign-in is not enabled"})
+		return
+	}
+
+	var req struct {
+		Email string `json:"user_email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Email is required"})
+		return
+	}
+
+	nonce, wait, err := services.RequestMagicLink(req.Email, c.ClientIP())
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"message": "Please wait before requesting another sign-in link."})
+		return
+	}
+	if err != nil {
+		log.Printf("Magic link request failed: %v", err)
+	}
+
+	if nonce != "" {
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(magicLinkNonceCookie, nonce, int(cfg.MagicLinkTTL.Seconds()), "/api/v1/auth/magic-link", cfg.CookieDomain, cfg.CookieSecure, true)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "If that email belongs to an account, a sign-in link has been sent."})
+}
+
+// POST /api/v1/auth/magic-link/redeem
+// Responds like Login: tokens, or an MFA challenge.
+func (h *AuthHandler) RedeemMagicLink(c *gin.Context) {
+	cfg := config.Load()
+	if !cfg.MagicLinkEnabled {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Email sign-in is not enabled"})
+		return
+	}
+
+	var req struct {
+		Token    string `json:"token"`
+		DeviceID string `json:"device_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid request", "error": err.Error()})
+		return
+	}
+
+	nonce, _ := c.Cookie(magicLinkNonceCookie)
+	user, err := services.RedeemMagicLink(req.Token, nonce, c.ClientIP())
+	if errors.Is(err, services.ErrInvalidMagicLink) {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+		return
+	} else if err != nil {
+		log.Printf("Magic link redemption failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not sign in"})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(magicLinkNonceCookie, "", -1, "/api/v1/auth/magic-link", cfg.CookieDomain, cfg.CookieSecure, true)
+
+	result, err := services.CompleteLogin(user, clientInfo(c, req.DeviceID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not generate token"})
+		return
+	}
+	respondWithLogin(c, result)
+}
+
+// GET /.well-known/jwks.json
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	jwks, err := services.JWKS()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not load signing keys"})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, jwks)
+}
+
+// clientInfo collects the device details recorded alongside a refresh token.
+func clientInfo(c *gin.Context, deviceID string) services.ClientInfo {
+	return services.ClientInfo{
+		DeviceID:  deviceID,
+		UserAgent: c.Request.UserAgent(),
+		IPAddress: c.ClientIP(),
+	}
 }
@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"errors"
 	"goserver/internal/models"
 	"goserver/internal/services"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -14,8 +16,17 @@ func NewBlogHandler() *BlogHandler {
 	return &BlogHandler{}
 }
 
+// GET /api/v1/blog/
+// Lists published posts. Signed-in callers also see their own drafts,
+// scheduled and archived posts.
 func (h *BlogHandler) GetAll(c *gin.Context) {
-	blogs, err := services.GetAllBlogs()
+	viewer := ""
+	if user, ok := c.Get("user"); ok {
+		if u, ok := user.(*models.DbUser); ok {
+			viewer = u.Username
+		}
+	}
+	blogs, err := services.GetAllBlogs(viewer)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -34,6 +45,7 @@ func (h *BlogHandler) GetByID(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Blog not found"})
 		return
 	}
+	c.Header("ETag", services.BlogETag(blog))
 	c.JSON(http.StatusOK, blog)
 }
 
@@ -43,9 +55,20 @@ func (h *BlogHandler) Create(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// Edits go through Update, which checks ownership and the ETag
+	blog.ID = 0
 
-	id, err := services.SaveBlog(&blog)
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	id, err := services.SaveBlog(&blog, user)
 	if err != nil {
+		if isBlogStatusError(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -56,6 +79,105 @@ func (h *BlogHandler) Create(c *gin.Context) {
 	})
 }
 
+// PUT /api/v1/blog/:id
+// Replaces the title, body and category. Requires If-Match with the ETag
+// from GET /blog/:id.
+func (h *BlogHandler) Update(c *gin.Context) {
+	var req services.BlogUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Title == nil || req.Content == nil || req.Category == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "blog_subject, blog_body and blog_category are required; use PATCH to change only some of them"})
+		return
+	}
+	h.save(c, &req)
+}
+
+// PATCH /api/v1/blog/:id
+// Changes only the fields sent. Requires If-Match like PUT.
+func (h *BlogHandler) Patch(c *gin.Context) {
+	var req services.BlogUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.save(c, &req)
+}
+
+// save applies an edit to the post loaded by VerifyBlogExists, provided the
+// client's If-Match still names its current version
+func (h *BlogHandler) save(c *gin.Context, req *services.BlogUpdateRequest) {
+	blog, user, ok := editableBlog(c)
+	if !ok {
+		return
+	}
+
+	if req.Title != nil && strings.TrimSpace(*req.Title) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "blog_subject cannot be empty"})
+		return
+	}
+
+	updated := req.Apply(blog)
+	if _, err := services.SaveBlog(updated, user); err != nil {
+		if errors.Is(err, services.ErrBlogModified) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
+		if isBlogStatusError(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("ETag", services.BlogETag(updated))
+	c.JSON(http.StatusOK, updated)
+}
+
+// editableBlog returns the post loaded by VerifyBlogExists and the editor,
+// provided the client's If-Match still names the post's current version
+func editableBlog(c *gin.Context) (*models.DbBlog, *models.DbUser, bool) {
+	stored, _ := c.Get("blog")
+	blog, ok := stored.(*models.DbBlog)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve blog information"})
+		return nil, nil, false
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return nil, nil, false
+	}
+
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header with the post's ETag is required"})
+		return nil, nil, false
+	}
+	if !etagMatches(ifMatch, services.BlogETag(blog)) {
+		c.Header("ETag", services.BlogETag(blog))
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": services.ErrBlogModified.Error()})
+		return nil, nil, false
+	}
+	return blog, user, true
+}
+
+func isBlogStatusError(err error) bool {
+	return errors.Is(err, services.ErrInvalidBlogStatus) || errors.Is(err, services.ErrPublishAtRequired)
+}
+
+// etagMatches reports whether an If-Match header lists the ETag
+func etagMatches(ifMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(candidate) == etag {
+			return true
+		}
+	}
+	return false
+}
+
 func (h *BlogHandler) Delete(c *gin.Context) {
 	id := c.Param("id")
 	err := services.DeleteBlog(id)
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"goserver/internal/models"
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loadedBlog returns the post set by VerifyBlogExists
+func loadedBlog(c *gin.Context) (*models.DbBlog, bool) {
+	stored, _ := c.Get("blog")
+	blog, ok := stored.(*models.DbBlog)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve blog information"})
+	}
+	return blog, ok
+}
+
+// revisionNumber parses a positive revision number, responding on failure
+func revisionNumber(c *gin.Context, value, name string) (int, bool) {
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be a revision number"})
+		return 0, false
+	}
+	return number, true
+}
+
+func respondRevisionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrRevisionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrBlogModified):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// GET /api/v1/blog/:id/revisions
+// Lists the post's revisions, newest first, without their bodies
+func (h *BlogHandler) ListRevisions(c *gin.Context) {
+	blog, ok := loadedBlog(c)
+	if !ok {
+		return
+	}
+	revisions, err := services.GetBlogRevisions(blog.ID)
+	if err != nil {
+		respondRevisionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, revisions)
+}
+
+// GET /api/v1/blog/:id/revisions/:revision
+func (h *BlogHandler) GetRevision(c *gin.Context) {
+	blog, ok := loadedBlog(c)
+	if !ok {
+		return
+	}
+	number, ok := revisionNumber(c, c.Param("revision"), "revision")
+	if !ok {
+		return
+	}
+	revision, err := services.GetBlogRevision(blog.ID, number)
+	if err != nil {
+		respondRevisionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, revision)
+}
+
+// GET /api/v1/blog/:id/revisions/diff?from=1&to=3
+// Line-level diff of the body between two revisions
+func (h *BlogHandler) DiffRevisions(c *gin.Context) {
+	blog, ok := loadedBlog(c)
+	if !ok {
+		return
+	}
+	from, ok := revisionNumber(c, c.Query("from"), "from")
+	if !ok {
+		return
+	}
+	to, ok := revisionNumber(c, c.Query("to"), "to")
+	if !ok {
+		return
+	}
+	diff, err := services.DiffBlogRevisions(blog.ID, from, to)
+	if err != nil {
+		respondRevisionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, diff)
+}
+
+// POST /api/v1/blog/:id/revisions/:revision/restore
+// Saves the revision's content as a new revision. Requires If-Match with the
+// post's current ETag, like PUT.
+func (h *BlogHandler) RestoreRevision(c *gin.Context) {
+	number, ok := revisionNumber(c, c.Param("revision"), "revision")
+	if !ok {
+		return
+	}
+	blog, user, ok := editableBlog(c)
+	if !ok {
+		return
+	}
+
+	restored, newNumber, err := services.RestoreBlogRevision(blog, number, user)
+	if err != nil {
+		respondRevisionError(c, err)
+		return
+	}
+
+	c.Header("ETag", services.BlogETag(restored))
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Revision restored",
+		"revision": newNumber,
+		"blog":     restored,
+	})
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EmailChangeHandler struct{}
+
+func NewEmailChangeHandler() *EmailChangeHandler {
+	return &EmailChangeHandler{}
+}
+
+// GET /api/v1/users/me/email
+func (h *EmailChangeHandler) Get(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	pending, err := services.GetPendingEmailChange(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user_email": user.Email, "pending": pending})
+}
+
+// POST /api/v1/users/me/email
+func (h *EmailChangeHandler) Start(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Email string `json:"user_email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	validationErrors, err := services.StartEmailChange(user, req.Email)
+	if len(validationErrors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "errors": validationErrors})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Check your new email address for a confirmation link. Your email won't change until you confirm it."})
+}
+
+// DELETE /api/v1/users/me/email
+func (h *EmailChangeHandler) CancelMine(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if err := services.CancelUserEmailChange(user.ID); err != nil {
+		if errors.Is(err, services.ErrNoEmailChange) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email change cancelled"})
+}
+
+// POST /api/v1/users/email/confirm
+// The code comes from the link sent to the new address.
+func (h *EmailChangeHandler) Confirm(c *gin.Context) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Confirmation code is required"})
+		return
+	}
+
+	user, err := services.ConfirmEmailChange(req.Code)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidEmailChange) || errors.Is(err, services.ErrEmailUnavailable) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Your email address has been changed", "user_email": user.Email})
+}
+
+// POST /api/v1/users/email/cancel
+// The token comes from the link sent to the old address.
+func (h *EmailChangeHandler) Cancel(c *gin.Context) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cancel token is required"})
+		return
+	}
+
+	if err := services.CancelEmailChange(req.Token); err != nil {
+		if errors.Is(err, services.ErrInvalidEmailChange) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email change cancelled. If you didn't request it, please reset your password."})
+}
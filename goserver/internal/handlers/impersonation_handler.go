@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ImpersonationHandler struct{}
+
+func NewImpersonationHandler() *ImpersonationHandler {
+	return &ImpersonationHandler{}
+}
+
+// POST /api/v1/admin/users/:id/impersonate
+// Returns a short-lived, read-only access token acting as the user.
+func (h *ImpersonationHandler) Start(c *gin.Context) {
+	admin, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if _, impersonating := c.Get("actor"); impersonating {
+		c.JSON(http.StatusForbidden, gin.H{"error": services.ErrAlreadyImpersonating.Error()})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	_ = c.ShouldBindJSON(&req)
+
+	impersonation, err := services.StartImpersonation(admin, id, req.Reason, c.ClientIP())
+	switch {
+	case errors.Is(err, services.ErrImpersonationReason), errors.Is(err, services.ErrImpersonateSelf):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, services.ErrImpersonationForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, impersonation)
+	}
+}
+
+// POST /api/v1/auth/impersonation/end
+// Called with the impersonation token to revoke it early.
+func (h *ImpersonationHandler) End(c *gin.Context) {
+	actorID, impersonating := c.Get("actorID")
+	if !impersonating {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "This session is not impersonating anyone"})
+		return
+	}
+	id, _ := actorID.(float64)
+	userID, _ := c.Get("userID")
+	uid, _ := userID.(float64)
+	expires, _ := c.Get("tokenExpires")
+	expiresAt, _ := expires.(time.Time)
+
+	if err := services.EndImpersonation(int(id), int(uid), c.GetString("jti"), c.GetString("sessionID"), c.ClientIP(), expiresAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not end impersonation"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Impersonation ended"})
+}
+
+// GET /api/v1/admin/audit-log?actor_id=&user_id=&session_id=&limit=100
+func (h *ImpersonationHandler) AuditLog(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	actorID, _ := strconv.Atoi(c.Query("actor_id"))
+	userID, _ := strconv.Atoi(c.Query("user_id"))
+
+	entries, err := services.GetAuditLog(actorID, userID, c.Query("session_id"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
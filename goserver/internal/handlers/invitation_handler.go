@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type InvitationHandler struct{}
+
+func NewInvitationHandler() *InvitationHandler {
+	return &InvitationHandler{}
+}
+
+// GET /api/v1/admin/invitations
+func (h *InvitationHandler) List(c *gin.Context) {
+	invitations, err := services.GetInvitations()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, invitations)
+}
+
+// POST /api/v1/admin/invitations
+func (h *InvitationHandler) Create(c *gin.Context) {
+	inviter, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var req services.CreateInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	invitation, validationErrors, err := services.CreateInvitation(inviter, &req)
+	if len(validationErrors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "errors": validationErrors})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, invitation)
+}
+
+// POST /api/v1/admin/invitations/:id/resend
+func (h *InvitationHandler) Resend(c *gin.Context) {
+	sender, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation ID"})
+		return
+	}
+
+	var req struct {
+		ExpiresInDays int `json:"expires_in_days"`
+	}
+	// The expiry is optional, so an empty body is fine
+	_ = c.ShouldBindJSON(&req)
+
+	invitation, validationErrors, err := services.ResendInvitation(id, sender, req.ExpiresInDays)
+	switch {
+	case len(validationErrors) > 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "errors": validationErrors})
+	case errors.Is(err, services.ErrInvitationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvitationClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, invitation)
+	}
+}
+
+// DELETE /api/v1/admin/invitations/:id
+func (h *InvitationHandler) Revoke(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation ID"})
+		return
+	}
+
+	err = services.RevokeInvitation(id)
+	switch {
+	case errors.Is(err, services.ErrInvitationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvitationClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked"})
+	}
+}
+
+// POST /api/v1/auth/invitation
+// Shows the accept page who the invitation is for. The token is sent in the
+// body so it stays out of request logs.
+func (h *InvitationHandler) Lookup(c *gin.Context) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invitation token is required"})
+		return
+	}
+
+	invitation, err := services.LookupInvitation(req.Token)
+	if errors.Is(err, services.ErrInvalidInvitation) {
+		c.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not look up invitation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"email":      invitation.Email,
+		"role":       invitation.Role,
+		"expires_at": invitation.ExpiresAt,
+	})
+}
+
+// POST /api/v1/auth/invitation/accept
+// Creates the account and signs the new user in like Login.
+func (h *InvitationHandler) Accept(c *gin.Context) {
+	var req services.AcceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invitation token is required"})
+		return
+	}
+
+	user, validationErrors, err := services.AcceptInvitation(&req)
+	if len(validationErrors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Validation failed", "errors": validationErrors})
+		return
+	}
+	if errors.Is(err, services.ErrInvalidInvitation) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	} else if err != nil {
+		log.Printf("Invitation acceptance failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not create account"})
+		return
+	}
+
+	result, err := services.CompleteLogin(user, clientInfo(c, req.DeviceID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not generate token"})
+		return
+	}
+	respondWithLogin(c, result)
+}
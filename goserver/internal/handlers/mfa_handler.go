@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"goserver/internal/models"
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MFAHandler struct{}
+
+func NewMFAHandler() *MFAHandler {
+	return &MFAHandler{}
+}
+
+// POST /api/v1/auth/mfa/verify
+func (h *MFAHandler) Verify(c *gin.Context) {
+	var req struct {
+		MFAToken     string `json:"mfaToken"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+		DeviceID     string `json:"device_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.MFAToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "MFA token and code are required"})
+		return
+	}
+
+	user, err := services.CompleteMFAChallenge(req.MFAToken, req.Code, req.RecoveryCode, c.ClientIP())
+	if err != nil {
+		var throttled *services.LoginThrottledError
+		if errors.As(err, &throttled) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"message": throttled.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidMFAChallenge) || errors.Is(err, services.ErrInvalidMFACode) {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not verify code"})
+		return
+	}
+
+	tokens, err := services.IssueTokenPair(user, clientInfo(c, req.DeviceID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not generate token"})
+		return
+	}
+
+	respondWithTokens(c, tokens, nil, wantsCookieAuth(c))
+}
+
+// POST /api/v1/auth/mfa/enroll
+func (h *MFAHandler) Enroll(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfaToken"`
+	}
+	_ = c.ShouldBindJSON(&req)
+
+	user, ok := enrollingUser(c, req.MFAToken)
+	if !ok {
+		return
+	}
+
+	enrollment, err := services.BeginMFAEnrollment(user)
+	if err != nil {
+		if errors.Is(err, services.ErrMFAAlreadyEnabled) {
+			c.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not start enrollment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// POST /api/v1/auth/mfa/enroll/confirm
+func (h *MFAHandler) ConfirmEnrollment(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfaToken"`
+		Code     string `json:"code"`
+		DeviceID string `json:"device_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Code is required"})
+		return
+	}
+
+	user, ok := enrollingUser(c, req.MFAToken)
+	if !ok {
+		return
+	}
+
+	recoveryCodes, err := services.ConfirmMFAEnrollment(user.ID, req.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidMFACode), errors.Is(err, services.ErrMFANotPending):
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		case errors.Is(err, services.ErrMFAAlreadyEnabled):
+			c.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not confirm enrollment"})
+		}
+		return
+	}
+
+	response := gin.H{
+		"message":       "Two-factor authentication enabled. Store these recovery codes somewhere safe.",
+		"recoveryCodes": recoveryCodes,
+	}
+
+	// Enrollment forced at login completes the sign-in as well
+	if req.MFAToken != "" {
+		tokens, err := services.IssueTokenPair(user, clientInfo(c, req.DeviceID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not generate token"})
+			return
+		}
+		respondWithTokens(c, tokens, response, wantsCookieAuth(c))
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// POST /api/v1/auth/mfa/disable
+func (h *MFAHandler) Disable(c *gin.Context) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Code is required"})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if err := services.DisableMFA(user, req.Code); err != nil {
+		switch {
+		case errors.Is(err, services.ErrMFARequiredByRole):
+			c.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		case errors.Is(err, services.ErrInvalidMFACode):
+			c.JSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not disable two-factor authentication"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// enrollingUser resolves the user from an enrollment challenge token, or from
+// the bearer token when a signed-in user enrolls voluntarily.
+func enrollingUser(c *gin.Context, mfaToken string) (*models.DbUser, bool) {
+	if mfaToken != "" {
+		user, err := services.ParseMFAChallenge(mfaToken, services.MFAChallengeEnroll)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+			return nil, false
+		}
+		return user, true
+	}
+	return currentUser(c)
+}
+
+// currentUser returns the user RequireAuth attached to the request
+func currentUser(c *gin.Context) (*models.DbUser, bool) {
+	if user, ok := c.Get("user"); ok {
+		if u, ok := user.(*models.DbUser); ok {
+			return u, true
+		}
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"message": "Authentication required"})
+	return nil, false
+}
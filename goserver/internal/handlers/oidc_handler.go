@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"goserver/internal/config"
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oidcStateCookie binds the login to the browser that started it, so a
+// callback URL can't be replayed from somewhere else.
+const oidcStateCookie = "oidc_state"
+
+type OIDCHandler struct{}
+
+func NewOIDCHandler() *OIDCHandler {
+	return &OIDCHandler{}
+}
+
+// GET /api/v1/auth/oidc/providers
+func (h *OIDCHandler) Providers(c *gin.Context) {
+	c.JSON(http.StatusOK, services.GetOIDCProviders())
+}
+
+// GET /api/v1/auth/oidc/:provider/login?device_id=...
+// Redirects the browser to the identity provider.
+func (h *OIDCHandler) Login(c *gin.Context) {
+	authURL, state, err := services.BeginOIDCLogin(c.Param("provider"), c.Query("device_id"))
+	if errors.Is(err, services.ErrOIDCUnknownProvider) {
+		c.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	} else if err != nil {
+		log.Printf("OIDC login for %s failed to start: %v", c.Param("provider"), err)
+		c.JSON(http.StatusBadGateway, gin.H{"message": "Could not reach the identity provider"})
+		return
+	}
+
+	setOIDCStateCookie(c, state)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// POST /api/v1/auth/oidc/:provider/link
+// Starts linking the provider to the signed-in account. The client sends
+// the browser to authUrl; the callback reports the result with "linked".
+func (h *OIDCHandler) Link(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	authURL, state, err := services.BeginOIDCLink(c.Param("provider"), user)
+	if errors.Is(err, services.ErrOIDCUnknownProvider) {
+		c.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	} else if err != nil {
+		log.Printf("OIDC link for %s failed to start: %v", c.Param("provider"), err)
+		c.JSON(http.StatusBadGateway, gin.H{"message": "Could not reach the identity provider"})
+		return
+	}
+
+	setOIDCStateCookie(c, state)
+	c.JSON(http.StatusOK, gin.H{"authUrl": authURL})
+}
+
+func setOIDCStateCookie(c *gin.Context, state string) {
+	cfg := config.Load()
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, 600, "/api/v1/auth/oidc", cfg.CookieDomain, cfg.CookieSecure, true)
+}
+
+// GET /api/v1/auth/oidc/:provider/callback?code=...&state=...
+// Finishes the login and hands the result to the frontend in the URL
+// fragment, which never reaches server logs.
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	cfg := config.Load()
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, "/api/v1/auth/oidc", cfg.CookieDomain, cfg.CookieSecure, true)
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		log.Printf("OIDC provider %s returned error: %s", c.Param("provider"), providerErr)
+		redirectOIDCResult(c, url.Values{"error": {services.ErrOIDCFailed.Error()}})
+		return
+	}
+
+	state := c.Query("state")
+	cookieState, _ := c.Cookie(oidcStateCookie)
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookieState)) != 1 {
+		redirectOIDCResult(c, url.Values{"error": {services.ErrOIDCInvalidState.Error()}})
+		return
+	}
+
+	outcome, err := services.FinishOIDCLogin(c.Request.Context(), c.Param("provider"), state, c.Query("code"))
+	if err != nil {
+		message := err.Error()
+		if !errors.Is(err, services.ErrOIDCInvalidState) && !errors.Is(err, services.ErrOIDCFailed) &&
+			!errors.Is(err, services.ErrOIDCEmailUnverified) && !errors.Is(err, services.ErrOIDCLinkRequired) &&
+			!errors.Is(err, services.ErrOIDCIdentityInUse) {
+			log.Printf("OIDC login failed: %v", err)
+			message = services.ErrOIDCFailed.Error()
+		}
+		redirectOIDCResult(c, url.Values{"error": {message}})
+		return
+	}
+
+	if outcome.Linked {
+		redirectOIDCResult(c, url.Values{"linked": {c.Param("provider")}})
+		return
+	}
+
+	result, err := services.CompleteLogin(outcome.User, clientInfo(c, outcome.DeviceID))
+	if err != nil {
+		log.Printf("OIDC login could not issue tokens: %v", err)
+		redirectOIDCResult(c, url.Values{"error": {"Could not generate token"}})
+		return
+	}
+
+	if result.Tokens != nil {
+		redirectOIDCResult(c, url.Values{
+			"accessToken":  {result.Tokens.AccessToken},
+			"refreshToken": {result.Tokens.RefreshToken},
+			"expiresIn":    {strconv.FormatInt(result.Tokens.ExpiresIn, 10)},
+		})
+		return
+	}
+
+	redirectOIDCResult(c, url.Values{
+		"mfaRequired":           {strconv.FormatBool(result.MFARequired)},
+		"mfaEnrollmentRequired": {strconv.FormatBool(result.MFAEnrollmentRequired)},
+		"mfaToken":              {result.MFAToken},
+	})
+}
+
+func redirectOIDCResult(c *gin.Context, values url.Values) {
+	c.Redirect(http.StatusFound, config.Load().FrontendURL+"/oidc-callback#"+values.Encode())
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PasskeyHandler struct{}
+
+func NewPasskeyHandler() *PasskeyHandler {
+	return &PasskeyHandler{}
+}
+
+// GET /api/v1/auth/passkeys
+func (h *PasskeyHandler) List(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	passkeys, err := services.GetPasskeys(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, passkeys)
+}
+
+// DELETE /api/v1/auth/passkeys/:id
+func (h *PasskeyHandler) Delete(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passkey ID"})
+		return
+	}
+
+	if err := services.DeletePasskey(user.ID, id); err != nil {
+		if errors.Is(err, services.ErrPasskeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Passkey deleted successfully"})
+}
+
+// POST /api/v1/auth/passkeys/register/begin
+func (h *PasskeyHandler) BeginRegistration(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	sessionID, options, err := services.BeginPasskeyRegistration(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not start passkey registration"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessionId": sessionID, "options": options})
+}
+
+// POST /api/v1/auth/passkeys/register/finish?session_id=...&name=...
+// The body is the PublicKeyCredential returned by navigator.credentials.create.
+func (h *PasskeyHandler) FinishRegistration(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	passkey, err := services.FinishPasskeyRegistration(user, c.Query("session_id"), c.Query("name"), c.Request)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPasskeySession) || errors.Is(err, services.ErrPasskeyFailed) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not register passkey"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, passkey)
+}
+
+// POST /api/v1/auth/passkeys/login/begin
+func (h *PasskeyHandler) BeginLogin(c *gin.Context) {
+	var req struct {
+		Name string `json:"user_name"`
+	}
+	// The username is optional; without it the browser picks a discoverable passkey.
+	_ = c.ShouldBindJSON(&req)
+
+	sessionID, options, err := services.BeginPasskeyLogin(req.Name)
+	if err != nil {
+		if errors.Is(err, services.ErrPasskeyUnavailable) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error(), "passwordLogin": true})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not start passkey login"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessionId": sessionID, "options": options})
+}
+
+// POST /api/v1/auth/passkeys/login/finish?session_id=...&device_id=...
+// The body is the PublicKeyCredential returned by navigator.credentials.get.
+// Passkey logins require user verification, so the assertion covers both
+// factors and tokens are issued without a separate MFA step.
+func (h *PasskeyHandler) FinishLogin(c *gin.Context) {
+	user, err := services.FinishPasskeyLogin(c.Query("session_id"), c.Request)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPasskeySession) || errors.Is(err, services.ErrPasskeyFailed) {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not complete passkey login"})
+		return
+	}
+
+	tokens, err := services.IssueTokenPair(user, clientInfo(c, c.Query("device_id")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Could not generate token"})
+		return
+	}
+
+	respondWithTokens(c, tokens, nil, wantsCookieAuth(c))
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PersonalTokenHandler struct{}
+
+func NewPersonalTokenHandler() *PersonalTokenHandler {
+	return &PersonalTokenHandler{}
+}
+
+// GET /api/v1/users/me/tokens
+func (h *PersonalTokenHandler) List(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	tokens, err := services.GetPersonalTokens(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// POST /api/v1/users/me/tokens
+// The raw token is only ever returned in this response.
+func (h *PersonalTokenHandler) Create(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var req services.CreatePersonalTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, rawToken, validationErrors, err := services.CreatePersonalToken(user.ID, &req)
+	if len(validationErrors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "errors": validationErrors})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"token": rawToken, "details": token})
+}
+
+// DELETE /api/v1/users/me/tokens/:id
+func (h *PersonalTokenHandler) Revoke(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+
+	if err := services.RevokePersonalToken(user.ID, id); err != nil {
+		if errors.Is(err, services.ErrPersonalTokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked successfully"})
+}
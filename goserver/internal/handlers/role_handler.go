@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RoleHandler struct{}
+
+func NewRoleHandler() *RoleHandler {
+	return &RoleHandler{}
+}
+
+// GET /api/v1/admin/roles
+func (h *RoleHandler) List(c *gin.Context) {
+	c.JSON(http.StatusOK, services.GetRoles())
+}
+
+// GET /api/v1/admin/permissions
+func (h *RoleHandler) Permissions(c *gin.Context) {
+	permissions, err := services.GetPermissions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, permissions)
+}
+
+// POST /api/v1/admin/roles
+func (h *RoleHandler) Create(c *gin.Context) {
+	var req services.RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, validationErrors, err := services.CreateRole(&req)
+	if len(validationErrors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "errors": validationErrors})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, role)
+}
+
+// PUT /api/v1/admin/roles/:name
+func (h *RoleHandler) Update(c *gin.Context) {
+	var req services.RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, validationErrors, err := services.UpdateRole(c.Param("name"), &req)
+	if len(validationErrors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "errors": validationErrors})
+		return
+	}
+	if errors.Is(err, services.ErrRoleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+// DELETE /api/v1/admin/roles/:name
+func (h *RoleHandler) Delete(c *gin.Context) {
+	err := services.DeleteRole(c.Param("name"))
+	switch {
+	case errors.Is(err, services.ErrRoleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRoleInUse), errors.Is(err, services.ErrRoleProtected):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "Role deleted successfully"})
+	}
+}
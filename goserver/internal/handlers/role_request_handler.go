@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"goserver/internal/models"
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RoleRequestHandler struct{}
+
+func NewRoleRequestHandler() *RoleRequestHandler {
+	return &RoleRequestHandler{}
+}
+
+// GET /api/v1/users/me/role-requests
+func (h *RoleRequestHandler) ListMine(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	requests, err := services.GetUserRoleRequests(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, requests)
+}
+
+// POST /api/v1/users/me/role-requests
+func (h *RoleRequestHandler) Create(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+		Note string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	request, validationErrors, err := services.CreateRoleRequest(user, req.Role, req.Note)
+	if len(validationErrors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "errors": validationErrors})
+		return
+	}
+	if errors.Is(err, services.ErrRoleRequestPending) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, request)
+}
+
+// GET /api/v1/admin/role-requests?status=pending
+// Pass status= (empty) to list every request.
+func (h *RoleRequestHandler) List(c *gin.Context) {
+	requests, err := services.GetRoleRequests(c.DefaultQuery("status", models.RoleRequestPending))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, requests)
+}
+
+// POST /api/v1/admin/role-requests/:id/approve
+func (h *RoleRequestHandler) Approve(c *gin.Context) {
+	h.review(c, true)
+}
+
+// POST /api/v1/admin/role-requests/:id/deny
+func (h *RoleRequestHandler) Deny(c *gin.Context) {
+	h.review(c, false)
+}
+
+func (h *RoleRequestHandler) review(c *gin.Context, approve bool) {
+	reviewer, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role request ID"})
+		return
+	}
+
+	var req struct {
+		Note string `json:"note"`
+	}
+	// The note is optional, so an empty body is fine
+	_ = c.ShouldBindJSON(&req)
+
+	request, err := services.ReviewRoleRequest(id, reviewer, approve, req.Note)
+	switch {
+	case errors.Is(err, services.ErrRoleRequestNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRoleRequestReviewed), errors.Is(err, services.ErrUnknownRole):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRoleRequestOwn), errors.Is(err, services.ErrRoleAboveReviewer):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, request)
+	}
+}
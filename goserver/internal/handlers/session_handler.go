@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"goserver/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SessionHandler struct{}
+
+func NewSessionHandler() *SessionHandler {
+	return &SessionHandler{}
+}
+
+// GET /api/v1/users/me/sessions
+func (h *SessionHandler) ListMine(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := services.GetSessions(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	current := c.GetString("sessionID")
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+// DELETE /api/v1/users/me/sessions/:id
+func (h *SessionHandler) RevokeMine(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	revokeSession(c, user.ID, c.Param("id"))
+}
+
+// DELETE /api/v1/users/me/sessions
+// Signs out every other device, keeping the session making the request.
+func (h *SessionHandler) RevokeOthers(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	count, err := services.RevokeOtherSessions(user.ID, c.GetString("sessionID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Other sessions signed out", "revoked": count})
+}
+
+// GET /api/v1/users/:id/sessions
+func (h *SessionHandler) ListForUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid user ID: %v", err)})
+		return
+	}
+
+	sessions, err := services.GetSessions(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+// DELETE /api/v1/users/:id/sessions/:sessionId
+func (h *SessionHandler) RevokeForUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid user ID: %v", err)})
+		return
+	}
+
+	revokeSession(c, id, c.Param("sessionId"))
+}
+
+// DELETE /api/v1/users/:id/sessions
+// Signs the user out of every device.
+func (h *SessionHandler) RevokeAllForUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid user ID: %v", err)})
+		return
+	}
+
+	if err := services.RevokeAllUserTokens(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "All sessions signed out"})
+}
+
+func revokeSession(c *gin.Context, userID int, sessionID string) {
+	if err := services.RevokeSession(userID, sessionID); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Session signed out"})
+}
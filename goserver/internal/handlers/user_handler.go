@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"goserver/internal/models"
 	"goserver/internal/services"
+	"log"
+	"math"
 	"net/http"
 	"strconv"
 
@@ -38,20 +41,157 @@ func (h *UserHandler) GetByID(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
+
+	// Only user managers see the full record; everyone else gets the public view
+	role, _ := c.Get("roles")
+	if roleName, ok := role.(string); ok && services.RoleHasPermission(roleName, models.PermUsersManage) {
+		c.JSON(http.StatusOK, user)
+		return
+	}
+	c.JSON(http.StatusOK, user.Public())
+}
+
+// GET /api/v1/users/me
+func (h *UserHandler) GetMe(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
 	c.JSON(http.StatusOK, user)
 }
 
-func (h *UserHandler) Create(c *gin.Context) {
-	var user models.DbUser
-	if err := c.ShouldBindJSON(&user); err != nil {
+// GET /api/v1/users/me/export
+// Streams a zip of everything stored about the caller.
+func (h *UserHandler) ExportMe(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	export, err := services.BuildUserExport(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	fileName := fmt.Sprintf("%s_data_%s.zip", user.Username, export.GeneratedAt.Format("20060102"))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", "attachment; filename="+fileName)
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	// The status is already sent, so a failure part way can only be logged
+	if err := export.WriteZip(c.Writer); err != nil {
+		log.Printf("Data export for user %d failed: %v", user.ID, err)
+	}
+}
+
+// PATCH /api/v1/users/me
+func (h *UserHandler) UpdateMe(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var req services.UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := services.CreateUser(&user); err != nil {
+
+	updated, validationErrors, err := services.UpdateProfile(user, &req)
+	if len(validationErrors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "errors": validationErrors})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusCreated, user)
+	c.JSON(http.StatusOK, updated)
+}
+
+// POST /api/v1/users/me/password
+// Every session is signed out, including this one.
+func (h *UserHandler) ChangePassword(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.CurrentPassword == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Current and new password are required"})
+		return
+	}
+
+	validationErrors, err := services.ChangePassword(user, req.CurrentPassword, req.NewPassword, c.ClientIP())
+	if len(validationErrors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password", "errors": validationErrors})
+		return
+	}
+	if err != nil {
+		if errors.Is(err, services.ErrWrongPassword) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if respondThrottled(c, err) {
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	clearAuthCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed. Please log in with your new password."})
+}
+
+// respondThrottled answers 429 with Retry-After if err is a lockout
+func respondThrottled(c *gin.Context, err error) bool {
+	var throttled *services.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": throttled.Error()})
+	return true
+}
+
+// DELETE /api/v1/users/me
+func (h *UserHandler) DeleteMe(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Password string `json:"user_password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is required to delete your account"})
+		return
+	}
+
+	if err := services.DeleteOwnAccount(user, req.Password, c.ClientIP()); err != nil {
+		if respondThrottled(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, services.ErrWrongPassword):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrLastAdmin):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	clearAuthCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Your account has been deleted"})
 }
 
 func (h *UserHandler) Update(c *gin.Context) {
@@ -61,17 +201,29 @@ func (h *UserHandler) Update(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid user ID: %v", err)})
 		return
 	}
+	caller, ok := currentUser(c)
+	if !ok {
+		return
+	}
 	var user models.DbUser
 	if err := c.ShouldBindJSON(&user); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if err := services.UpdateUser(id, &user); err != nil {
+	if err := services.UpdateUser(id, &user, caller); err != nil {
 		if err.Error() == "user not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
+		if errors.Is(err, services.ErrUnknownRole) || errors.Is(err, services.ErrEmailUnavailable) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrRoleNotGrantable) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -98,9 +250,49 @@ func (h *UserHandler) Delete(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
 }
 
+// POST /api/v1/users/:id/unlock
+func (h *UserHandler) Unlock(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid user ID: %v", err)})
+		return
+	}
+
+	user, err := services.GetUserByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := services.UnlockUserLogin(user.Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlocked successfully"})
+}
+
+// GET /api/v1/users/login-attempts?user_name=&ip=&failed=true&limit=100
+func (h *UserHandler) LoginAttempts(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	failedOnly := c.Query("failed") == "true"
+
+	attempts, err := services.GetLoginAttempts(c.Query("user_name"), c.Query("ip"), failedOnly, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, attempts)
+}
+
 func (h *UserHandler) VerifyEmail(c *gin.Context) {
 	var req struct {
-		Code string `json:"code"`
+		Code     string `json:"code"`
+		DeviceID string `json:"device_id"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Verification code is required"})
@@ -120,21 +312,19 @@ func (h *UserHandler) VerifyEmail(c *gin.Context) {
 		return
 	}
 
-	// Generate access token (implement your own token generation logic)
-	accessToken, tokenErr := services.GenerateAccessToken(user)
+	tokens, tokenErr := services.IssueTokenPair(user, clientInfo(c, req.DeviceID))
 	if tokenErr != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": tokenErr.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":     "Email verified successfully. You are now logged in.",
-		"accessToken": accessToken,
+	respondWithTokens(c, tokens, gin.H{
+		"message": "Email verified successfully. You are now logged in.",
 		"user": gin.H{
 			"id":         user.ID,
 			"user_name":  user.Username,
 			"user_email": user.Email,
 			"role":       user.Role,
 		},
-	})
+	}, wantsCookieAuth(c))
 }
@@ -1,20 +1,30 @@
 package middleware
 
 import (
+	"goserver/internal/config"
 	"goserver/internal/models"
 	"goserver/internal/services"
 	"net/http"
-	"os"
+	"slices"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
-func RequireAuth() gin.HandlerFunc {
-	secret := os.Getenv("JWT_SECRET")
+// RequireAuth authenticates the Bearer credential: either an access token
+// from a browser session, or a personal access token. Personal access tokens
+// are only accepted on routes that name scopes, and must hold all of them.
+// Without an Authorization header the access token cookie is used instead,
+// and state-changing requests must then carry the double-submit CSRF token.
+func RequireAuth(scopes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			if cookie, err := c.Cookie(services.AccessTokenCookie); err == nil && cookie != "" {
+				requireCookieAuth(c, cookie)
+				return
+			}
+		}
 		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing or invalid Authorization header"})
 			return
@@ -22,71 +32,260 @@ func RequireAuth() gin.HandlerFunc {
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
-			// Validate the alg is what you expect:
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return []byte(secret), nil
-		})
+		if strings.HasPrefix(tokenString, services.PersonalTokenPrefix) {
+			requirePersonalToken(c, tokenString, scopes)
+			return
+		}
+
+		requireAccessToken(c, tokenString)
+	}
+}
 
-		if err != nil || !token.Valid {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+// requireCookieAuth authenticates the access token cookie. Browsers attach
+// it to cross-site requests too, so anything but a safe method must echo
+// the CSRF cookie in a header a foreign site can't set.
+func requireCookieAuth(c *gin.Context, tokenString string) {
+	if !safeMethod(c.Request.Method) {
+		csrfCookie, _ := c.Cookie(services.CSRFCookie)
+		if !services.ValidCSRFToken(csrfCookie, c.GetHeader(services.CSRFHeader)) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Missing or invalid CSRF token"})
 			return
 		}
+	}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+	c.Set("authViaCookie", true)
+	requireAccessToken(c, tokenString)
+}
 
-			if roles, ok := claims["role"]; ok {
-				c.Set("roles", roles)
-			}
+// requireAccessToken authenticates a signed access token
+func requireAccessToken(c *gin.Context, tokenString string) {
+	claims, err := services.ParseAccessToken(tokenString)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+
+	jti, _ := claims["jti"].(string)
+	sessionID, _ := claims["sid"].(string)
+	userIDClaim, _ := claims["user"].(float64)
+	issuedAt := services.ClaimTime(claims, "iat")
+	if services.IsTokenRevoked(jti, sessionID, int(userIDClaim), issuedAt) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+		return
+	}
+
+	// An impersonation token stops working as soon as the admin behind it is
+	// signed out or loses the permission
+	var actor *models.DbUser
+	if actorID, ok := services.ImpersonatorID(claims); ok {
+		actor, err = services.GetUserByID(actorID)
+		if err != nil || services.IsTokenRevoked("", "", actorID, issuedAt) ||
+			!services.RoleHasPermission(actor.Role, models.PermUsersImpersonate) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			return
+		}
+		c.Set("actor", actor)
+		c.Set("actorID", float64(actorID))
+	}
+	c.Set("jti", jti)
+	c.Set("sessionID", sessionID)
+	c.Set("tokenExpires", services.ClaimTime(claims, "exp"))
+
+	if roles, ok := claims["role"]; ok {
+		c.Set("roles", roles)
+	}
 
-			if userID, ok := claims["user"]; ok {
-				c.Set("userID", userID)
+	if userID, ok := claims["user"]; ok {
+		c.Set("userID", userID)
 
-				if f, ok := userID.(float64); ok {
-					idInt := int(f)
-					user, _ := services.GetUserByID(idInt)
-					if user != nil {
-						c.Set("user", user)
-					}
-				}
+		if f, ok := userID.(float64); ok {
+			idInt := int(f)
+			user, _ := services.GetUserByID(idInt)
+			if user != nil {
+				c.Set("user", user)
 			}
 		}
+	}
+
+	if actor != nil {
+		serveImpersonated(c, actor, int(userIDClaim), sessionID)
+		return
+	}
+	c.Next()
+}
+
+// impersonationAllowedRoutes are the only state-changing routes an
+// impersonation token may call. Logout is not one of them, and refuses
+// impersonation tokens itself: it would also revoke the admin's own refresh
+// cookie.
+var impersonationAllowedRoutes = map[string]bool{
+	"/api/v1/auth/impersonation/end": true,
+}
+
+// impersonationDeniedRoutes are read-only routes an impersonation token still
+// may not call: they hand out the user's full data export or details of
+// their credentials, sessions and pending email change.
+var impersonationDeniedRoutes = map[string]bool{
+	"/api/v1/users/me/export":   true,
+	"/api/v1/users/me/tokens/":  true,
+	"/api/v1/users/me/sessions": true,
+	"/api/v1/users/me/email":    true,
+}
+
+// serveImpersonated runs a request made with an impersonation token. Such
+// sessions are read-only, and every request is written to the audit log
+// with both identities.
+func serveImpersonated(c *gin.Context, actor *models.DbUser, userID int, sessionID string) {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		if impersonationDeniedRoutes[c.FullPath()] {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This action is not allowed while impersonating a user"})
+		} else {
+			c.Next()
+		}
+	default:
+		if impersonationAllowedRoutes[c.FullPath()] {
+			c.Next()
+		} else {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This action is not allowed while impersonating a user"})
+		}
+	}
+
+	services.RecordAudit(&models.DbAuditEntry{
+		ActorID:   &actor.ID,
+		UserID:    &userID,
+		Action:    models.AuditImpersonationRequest,
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.RequestURI(),
+		Status:    c.Writer.Status(),
+		IPAddress: c.ClientIP(),
+		SessionID: sessionID,
+	})
+}
 
+// requirePersonalToken authenticates a personal access token. The context is
+// filled in the same way as for an access token, plus the token's "scopes".
+func requirePersonalToken(c *gin.Context, tokenString string, scopes []string) {
+	if len(scopes) == 0 {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Personal access tokens cannot be used here"})
+		return
+	}
+
+	user, granted, err := services.AuthenticatePersonalToken(tokenString)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Token is missing scope " + scope})
+			return
+		}
+	}
+
+	setPersonalTokenUser(c, user, granted)
+	c.Next()
+}
+
+// optionalPersonalToken signs in with a personal access token if it is valid
+// and has every scope, and otherwise carries on anonymously. Routes that name
+// no scopes don't accept personal access tokens, so they are always anonymous.
+func optionalPersonalToken(c *gin.Context, tokenString string, scopes []string) {
+	if len(scopes) == 0 {
 		c.Next()
+		return
 	}
+	user, granted, err := services.AuthenticatePersonalToken(tokenString)
+	if err != nil {
+		c.Next()
+		return
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			c.Next()
+			return
+		}
+	}
+	setPersonalTokenUser(c, user, granted)
+	c.Next()
 }
 
-// RequireRole creates middleware that requires specific roles
-func RequireRole(allowedRoles ...string) gin.HandlerFunc {
+func setPersonalTokenUser(c *gin.Context, user *models.DbUser, granted []string) {
+	c.Set("roles", user.Role)
+	c.Set("userID", float64(user.ID))
+	c.Set("user", user)
+	c.Set("scopes", granted)
+}
+
+// OptionalAuth lets anonymous requests through and signs in callers whose
+// credentials are usable. A stale, revoked or malformed token, or a personal
+// access token the route would not accept, is ignored and the request is
+// served anonymously, so a public page never turns a reader away. Valid
+// credentials still go through the usual checks. An access token cookie is
+// only used when it is still valid and, for unsafe methods, comes with the
+// CSRF token; a stale cookie is cleared.
+func OptionalAuth(scopes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userRole, exists := c.Get("roles")
-		if !exists {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "No role found"})
+		if authHeader := c.GetHeader("Authorization"); authHeader != "" {
+			tokenString, ok := strings.CutPrefix(authHeader, "Bearer ")
+			switch {
+			case !ok:
+				c.Next()
+			case strings.HasPrefix(tokenString, services.PersonalTokenPrefix):
+				optionalPersonalToken(c, tokenString, scopes)
+			case !accessTokenUsable(tokenString):
+				c.Next()
+			default:
+				requireAccessToken(c, tokenString)
+			}
 			return
 		}
 
-		role, ok := userRole.(string)
-		if !ok {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid role format"})
+		cookie, _ := c.Cookie(services.AccessTokenCookie)
+		if cookie == "" {
+			c.Next()
 			return
 		}
-
-		// Check if user's role is in allowed roles
-		for _, allowedRole := range allowedRoles {
-			if role == allowedRole {
+		if !accessTokenUsable(cookie) {
+			cfg := config.Load()
+			c.SetSameSite(http.SameSiteLaxMode)
+			c.SetCookie(services.AccessTokenCookie, "", -1, "/", cfg.CookieDomain, cfg.CookieSecure, true)
+			c.Next()
+			return
+		}
+		if !safeMethod(c.Request.Method) {
+			csrfCookie, _ := c.Cookie(services.CSRFCookie)
+			if !services.ValidCSRFToken(csrfCookie, c.GetHeader(services.CSRFHeader)) {
 				c.Next()
 				return
 			}
 		}
+		requireCookieAuth(c, cookie)
+	}
+}
 
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+// accessTokenUsable reports whether an access token is signed, unexpired and
+// not revoked
+func accessTokenUsable(tokenString string) bool {
+	claims, err := services.ParseAccessToken(tokenString)
+	if err != nil {
+		return false
 	}
+	jti, _ := claims["jti"].(string)
+	sessionID, _ := claims["sid"].(string)
+	userID, _ := claims["user"].(float64)
+	return !services.IsTokenRevoked(jti, sessionID, int(userID), services.ClaimTime(claims, "iat"))
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
 }
 
-// RequireMinLevel requires a minimum user level
-func RequireMinLevel(minLevel int) gin.HandlerFunc {
+// RequirePermission allows the request if the caller's role grants the
+// permission. Roles are resolved from the database-backed role cache, so
+// changes made through /admin/roles apply without new tokens.
+func RequirePermission(permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userRole, exists := c.Get("roles")
 		if !exists {
@@ -100,16 +299,7 @@ func RequireMinLevel(minLevel int) gin.HandlerFunc {
 			return
 		}
 
-		// Find user's role level
-		var userLevel int
-		for _, roleData := range models.USER_ROLES {
-			if roleData.Name == role {
-				userLevel = roleData.Level
-				break
-			}
-		}
-
-		if userLevel < minLevel {
+		if !services.RoleHasPermission(role, permission) {
 			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
 			return
 		}
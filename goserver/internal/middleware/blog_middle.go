@@ -57,9 +57,9 @@ func VerifyCommentOwnership() gin.HandlerFunc {
 		com := comment.(*models.DbComment)
 
 		isOwner := u.Username == com.Name || u.Email == com.Email
-		isAdmin := u.Role == "Admin"
+		isModerator := services.RoleHasPermission(u.Role, models.PermCommentModerate)
 
-		if !isOwner && !isAdmin {
+		if !isOwner && !isModerator {
 			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied. You can only modify your own comments or must be an admin."})
 			c.Abort()
 			return
@@ -82,9 +82,9 @@ func VerifyBlogOwnership() gin.HandlerFunc {
 		}
 
 		isOwner := u.Username == b.AuthorID
-		isAdmin := u.Role == "Admin"
+		isManager := services.RoleHasPermission(u.Role, models.PermBlogManage)
 
-		if !isOwner && !isAdmin {
+		if !isOwner && !isManager {
 			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied. You can only modify your own blog posts or must be an Admin."})
 			c.Abort()
 			return
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+)
+
+// Audit actions
+const (
+	AuditImpersonationStart   = "impersonation.start"
+	AuditImpersonationEnd     = "impersonation.end"
+	AuditImpersonationRequest = "impersonation.request"
+)
+
+// DbAuditEntry records something an admin did, or a request made on their
+// behalf. ActorName and UserName are joined from users.
+type DbAuditEntry struct {
+	ID        int       `json:"id" db:"id"`
+	ActorID   *int      `json:"actor_id" db:"actor_id"`
+	ActorName *string   `json:"actor_name" db:"actor_name"`
+	UserID    *int      `json:"user_id" db:"subject_user_id"`
+	UserName  *string   `json:"user_name" db:"subject_user_name"`
+	Action    string    `json:"action" db:"action"`
+	Method    string    `json:"method" db:"method"`
+	Path      string    `json:"path" db:"path"`
+	Status    int       `json:"status" db:"status"`
+	IPAddress string    `json:"ip_address" db:"ip_address"`
+	SessionID string    `json:"session_id" db:"session_id"`
+	Details   string    `json:"details" db:"details"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type AQueries struct {
+	Insert string
+	Search string
+}
+
+var AuditQueries = AQueries{
+	Insert: `
+        INSERT INTO audit_log (actor_id, subject_user_id, action, method, path, status, ip_address, session_id, details)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+    `,
+	Search: `
+        SELECT a.id, a.actor_id, actor.user_name AS actor_name, a.subject_user_id, subject.user_name AS subject_user_name,
+               a.action, a.method, a.path, a.status, a.ip_address, a.session_id, a.details, a.created_at
+        FROM audit_log a
+        LEFT JOIN users actor ON actor.id = a.actor_id
+        LEFT JOIN users subject ON subject.id = a.subject_user_id
+        WHERE ($1 = 0 OR a.actor_id = $1)
+          AND ($2 = 0 OR a.subject_user_id = $2)
+          AND ($3 = '' OR a.session_id = $3)
+        ORDER BY a.created_at DESC
+        LIMIT $4
+    `,
+}
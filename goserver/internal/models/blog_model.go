@@ -4,48 +4,99 @@ import (
 	"time"
 )
 
+const (
+	BlogDraft     = "draft"
+	BlogScheduled = "scheduled"
+	BlogPublished = "published"
+	BlogArchived  = "archived"
+)
+
+// DbBlog is a blog post. Only published posts are listed publicly; a
+// scheduled post is published by the scheduler once PublishAt has passed.
 type DbBlog struct {
-	ID        int       `json:"id" db:"id"`
-	Title     string    `json:"blog_subject" db:"blog_subject"`
-	Content   string    `json:"blog_body" db:"blog_body"`
-	AuthorID  string    `json:"blog_owner_name" db:"blog_owner_name"`
-	Email     string    `json:"blog_owner_email" db:"blog_owner_email"`
-	Category  string    `json:"blog_category" db:"blog_category"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	ID        int        `json:"id" db:"id"`
+	Title     string     `json:"blog_subject" db:"blog_subject"`
+	Content   string     `json:"blog_body" db:"blog_body"`
+	AuthorID  string     `json:"blog_owner_name" db:"blog_owner_name"`
+	Email     string     `json:"blog_owner_email" db:"blog_owner_email"`
+	Category  string     `json:"blog_category" db:"blog_category"`
+	Status    string     `json:"status" db:"status"`
+	PublishAt *time.Time `json:"publish_at" db:"publish_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
 }
 
 type BQueries struct {
-	GetAll  string
-	GetByID string
-	Insert  string
-	Update  string
-	Delete  string
+	GetAll       string
+	GetByID      string
+	Insert       string
+	Update       string
+	Delete       string
+	RenameOwner  string
+	GetByOwner   string
+	PublishDue   string
+	Unannounced  string
+	MarkNotified string
 }
 
 var BlogQueries = BQueries{
 	GetAll: `
-        SELECT id, blog_subject, blog_body, blog_owner_name, blog_owner_email, blog_category
+        SELECT id, blog_subject, blog_body, blog_owner_name, blog_owner_email, blog_category, status, publish_at, created_at, updated_at
         FROM blogs
+        WHERE status = 'published' OR ($1 <> '' AND blog_owner_name = $1)
     `,
 	GetByID: `
-        SELECT id, blog_subject, blog_body, blog_owner_name, blog_owner_email, blog_category
+        SELECT id, blog_subject, blog_body, blog_owner_name, blog_owner_email, blog_category, status, publish_at, created_at, updated_at
         FROM blogs 
         WHERE id = $1
     `,
 	Insert: `
-        INSERT INTO blogs (blog_subject, blog_body, blog_owner_name, blog_owner_email, blog_category) 
-        VALUES ($1, $2, $3, $4, $5) 
-        RETURNING id
+        INSERT INTO blogs (blog_subject, blog_body, blog_owner_name, blog_owner_email, blog_category, status, publish_at) 
+        VALUES ($1, $2, $3, $4, $5, $6, $7) 
+        RETURNING id, created_at, updated_at
     `,
+	// Only updates the version the caller loaded: $9 is updated_at in
+	// microseconds since the epoch, as used in the blog's ETag
 	Update: `
         UPDATE blogs 
-        SET blog_subject = $1, blog_body = $2, blog_owner_name = $3, blog_owner_email = $4, blog_category = $5, updated_at = CURRENT_TIMESTAMP
-        WHERE id = $6
-        RETURNING id, blog_subject, blog_body, blog_owner_name, blog_owner_email, blog_category
+        SET blog_subject = $1, blog_body = $2, blog_owner_name = $3, blog_owner_email = $4, blog_category = $5, status = $6, publish_at = $7, updated_at = CURRENT_TIMESTAMP
+        WHERE id = $8 AND (extract(epoch FROM updated_at) * 1000000)::bigint = $9
+        RETURNING created_at, updated_at
     `,
 	Delete: `
         DELETE FROM blogs
         WHERE id = $1
     `,
+	RenameOwner: `
+        UPDATE blogs
+        SET blog_owner_name = $1
+        WHERE blog_owner_name = $2
+    `,
+	GetByOwner: `
+        SELECT id, blog_subject, blog_body, blog_owner_name, blog_owner_email, blog_category, status, publish_at, created_at, updated_at
+        FROM blogs
+        WHERE blog_owner_name = $1 OR lower(blog_owner_email) = lower($2)
+        ORDER BY created_at
+    `,
+	PublishDue: `
+        UPDATE blogs
+        SET status = 'published', updated_at = CURRENT_TIMESTAMP
+        WHERE status = 'scheduled' AND publish_at <= CURRENT_TIMESTAMP
+        RETURNING id
+    `,
+	// Published posts whose announcement was never claimed, e.g. because
+	// the server stopped between publishing and notifying
+	Unannounced: `
+        SELECT id FROM blogs
+        WHERE status = 'published' AND notified_at IS NULL
+        ORDER BY id
+    `,
+	// Claims the one-off announcement of a published post; no row means it
+	// was already sent or the post is not published
+	MarkNotified: `
+        UPDATE blogs
+        SET notified_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND status = 'published' AND notified_at IS NULL
+        RETURNING id, blog_subject, blog_body, blog_owner_name, blog_owner_email, blog_category, status, publish_at, created_at, updated_at
+    `,
 }
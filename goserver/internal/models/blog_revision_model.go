@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+)
+
+// DbBlogRevision is an immutable snapshot written on every save of a post.
+// AuthorName is kept so the history survives the author's account.
+type DbBlogRevision struct {
+	ID           int       `json:"id" db:"id"`
+	BlogID       int       `json:"blog_id" db:"blog_id"`
+	Number       int       `json:"revision" db:"revision_number"`
+	Title        string    `json:"blog_subject" db:"blog_subject"`
+	Content      string    `json:"blog_body,omitempty" db:"blog_body"`
+	Category     string    `json:"blog_category" db:"blog_category"`
+	AuthorID     *int      `json:"author_id" db:"author_id"`
+	AuthorName   string    `json:"author_name" db:"author_name"`
+	RestoredFrom *int      `json:"restored_from" db:"restored_from"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+type BRQueries struct {
+	Insert         string
+	InsertBaseline string
+	GetByBlogID    string
+	GetByNumber    string
+	GetByAuthor    string
+	LockBlog       string
+}
+
+var BlogRevisionQueries = BRQueries{
+	Insert: `
+        INSERT INTO blog_revisions (blog_id, revision_number, blog_subject, blog_body, blog_category, author_id, author_name, restored_from)
+        SELECT $1, COALESCE(MAX(revision_number), 0) + 1, $2::text, $3::text, $4::text, $5::integer, $6::text, $7::integer
+        FROM blog_revisions
+        WHERE blog_id = $1
+        RETURNING revision_number
+    `,
+	// Records a post saved before revisions existed as its first revision
+	InsertBaseline: `
+        INSERT INTO blog_revisions (blog_id, revision_number, blog_subject, blog_body, blog_category, author_name, created_at)
+        SELECT id, 1, blog_subject, blog_body, blog_category, blog_owner_name, COALESCE(updated_at, created_at, CURRENT_TIMESTAMP)
+        FROM blogs
+        WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM blog_revisions WHERE blog_id = $1)
+    `,
+	// Leaves out the body; fetch a single revision for that
+	GetByBlogID: `
+        SELECT id, blog_id, revision_number, blog_subject, blog_category, author_id, author_name, restored_from, created_at
+        FROM blog_revisions
+        WHERE blog_id = $1
+        ORDER BY revision_number DESC
+    `,
+	GetByNumber: `
+        SELECT id, blog_id, revision_number, blog_subject, blog_body, blog_category, author_id, author_name, restored_from, created_at
+        FROM blog_revisions
+        WHERE blog_id = $1 AND revision_number = $2
+    `,
+	GetByAuthor: `
+        SELECT id, blog_id, revision_number, blog_subject, blog_body, blog_category, author_id, author_name, restored_from, created_at
+        FROM blog_revisions
+        WHERE author_id = $1
+        ORDER BY created_at
+    `,
+	// Taken before the baseline and update so concurrent saves number their
+	// revisions in order
+	LockBlog: `
+        SELECT id FROM blogs WHERE id = $1 FOR UPDATE
+    `,
+}
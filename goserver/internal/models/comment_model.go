@@ -23,6 +23,7 @@ type CQueries struct {
 	Insert      string
 	Update      string
 	Delete      string
+	GetByAuthor string
 }
 
 var CommentQueries = CQueries{
@@ -51,4 +52,11 @@ var CommentQueries = CQueries{
         DELETE FROM comments
         WHERE id = $1 AND comment_blog_id = $2
     `,
+	// Comments carry a free-text name, so only the email identifies the author
+	GetByAuthor: `
+        SELECT id, comment_blog_id, comment_name, comment_email, comment_body, comment_approved, created_at, updated_at
+        FROM comments
+        WHERE lower(comment_email) = lower($1)
+        ORDER BY created_at
+    `,
 }
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+)
+
+// DbEmailChange is a pending switch to a new email address. The code is
+// sent to the new address; the cancel token goes to the old one.
+type DbEmailChange struct {
+	ID          int        `json:"id" db:"id"`
+	UserID      int        `json:"user_id" db:"user_id"`
+	NewEmail    string     `json:"new_email" db:"new_email"`
+	CodeHash    string     `json:"-" db:"code_hash"`
+	CancelHash  string     `json:"-" db:"cancel_hash"`
+	ExpiresAt   time.Time  `json:"expires_at" db:"expires_at"`
+	ConfirmedAt *time.Time `json:"confirmed_at" db:"confirmed_at"`
+	CancelledAt *time.Time `json:"cancelled_at" db:"cancelled_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+}
+
+type ECQueries struct {
+	Insert                   string
+	GetPendingByUser         string
+	GetByCodeHashForUpdate   string
+	GetByCancelHashForUpdate string
+	MarkConfirmed            string
+	MarkCancelled            string
+	CancelForUser            string
+}
+
+var EmailChangeQueries = ECQueries{
+	Insert: `
+        INSERT INTO email_changes (user_id, new_email, code_hash, cancel_hash, expires_at)
+        VALUES ($1, $2, $3, $4, $5)
+    `,
+	GetPendingByUser: `
+        SELECT id, user_id, new_email, code_hash, cancel_hash, expires_at, confirmed_at, cancelled_at, created_at
+        FROM email_changes
+        WHERE user_id = $1 AND confirmed_at IS NULL AND cancelled_at IS NULL
+          AND expires_at > CURRENT_TIMESTAMP
+        ORDER BY created_at DESC
+        LIMIT 1
+    `,
+	GetByCodeHashForUpdate: `
+        SELECT id, user_id, new_email, code_hash, cancel_hash, expires_at, confirmed_at, cancelled_at, created_at
+        FROM email_changes
+        WHERE code_hash = $1
+        FOR UPDATE
+    `,
+	GetByCancelHashForUpdate: `
+        SELECT id, user_id, new_email, code_hash, cancel_hash, expires_at, confirmed_at, cancelled_at, created_at
+        FROM email_changes
+        WHERE cancel_hash = $1
+        FOR UPDATE
+    `,
+	MarkConfirmed: `
+        UPDATE email_changes
+        SET confirmed_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+    `,
+	MarkCancelled: `
+        UPDATE email_changes
+        SET cancelled_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+    `,
+	// Returns the ids of the changes it cancelled
+	CancelForUser: `
+        UPDATE email_changes
+        SET cancelled_at = CURRENT_TIMESTAMP
+        WHERE user_id = $1 AND confirmed_at IS NULL AND cancelled_at IS NULL
+        RETURNING id
+    `,
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+)
+
+// DbUserIdentity links a user to an account at an external OpenID Connect
+// provider. Subject is the provider's stable "sub" claim.
+type DbUserIdentity struct {
+	ID          int        `json:"id" db:"id"`
+	UserID      int        `json:"user_id" db:"user_id"`
+	Provider    string     `json:"provider" db:"provider"`
+	Subject     string     `json:"-" db:"subject"`
+	Email       string     `json:"email" db:"email"`
+	LastLoginAt *time.Time `json:"last_login_at" db:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+}
+
+type IdQueries struct {
+	GetBySubject string
+	Insert       string
+	TouchLogin   string
+	GetByUserID  string
+}
+
+var IdentityQueries = IdQueries{
+	GetBySubject: `
+        SELECT id, user_id, provider, subject, email, last_login_at, created_at
+        FROM user_identities
+        WHERE provider = $1 AND subject = $2
+    `,
+	Insert: `
+        INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+        VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+    `,
+	TouchLogin: `
+        UPDATE user_identities
+        SET email = $1, last_login_at = CURRENT_TIMESTAMP
+        WHERE id = $2
+    `,
+	GetByUserID: `
+        SELECT id, user_id, provider, subject, email, last_login_at, created_at
+        FROM user_identities
+        WHERE user_id = $1
+        ORDER BY created_at
+    `,
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"time"
+)
+
+// Invitation statuses, derived from the timestamps rather than stored
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationRevoked  = "revoked"
+	InvitationExpired  = "expired"
+)
+
+// DbInvitation is an admin's invitation for someone to join with a given
+// role. InvitedByName is joined from users.
+type DbInvitation struct {
+	ID             int        `json:"id" db:"id"`
+	Email          string     `json:"email" db:"email"`
+	Role           string     `json:"role" db:"role"`
+	TokenHash      string     `json:"-" db:"token_hash"`
+	InvitedBy      *int       `json:"invited_by" db:"invited_by"`
+	InvitedByName  *string    `json:"invited_by_name" db:"invited_by_name"`
+	Status         string     `json:"status" db:"status"`
+	ExpiresAt      time.Time  `json:"expires_at" db:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at" db:"accepted_at"`
+	AcceptedUserID *int       `json:"accepted_user_id" db:"accepted_user_id"`
+	RevokedAt      *time.Time `json:"revoked_at" db:"revoked_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+
+type InvQueries struct {
+	Insert             string
+	GetAll             string
+	GetByID            string
+	GetByHash          string
+	GetByHashForUpdate string
+	GetPendingByEmail  string
+	Revoke             string
+	Renew              string
+	MarkAccepted       string
+}
+
+const invitationColumns = `
+        SELECT i.id, i.email, i.role, i.token_hash, i.invited_by, u.user_name AS invited_by_name,
+               CASE
+                   WHEN i.accepted_at IS NOT NULL THEN 'accepted'
+                   WHEN i.revoked_at IS NOT NULL THEN 'revoked'
+                   WHEN i.expires_at <= CURRENT_TIMESTAMP THEN 'expired'
+                   ELSE 'pending'
+               END AS status,
+               i.expires_at, i.accepted_at, i.accepted_user_id, i.revoked_at, i.created_at
+        FROM invitations i
+        LEFT JOIN users u ON u.id = i.invited_by`
+
+var InvitationQueries = InvQueries{
+	Insert: `
+        INSERT INTO invitations (email, role, token_hash, invited_by, expires_at)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id
+    `,
+	GetAll: invitationColumns + `
+        ORDER BY i.created_at DESC
+    `,
+	GetByID: invitationColumns + `
+        WHERE i.id = $1
+    `,
+	GetByHash: invitationColumns + `
+        WHERE i.token_hash = $1
+    `,
+	GetByHashForUpdate: invitationColumns + `
+        WHERE i.token_hash = $1
+        FOR UPDATE OF i
+    `,
+	GetPendingByEmail: `
+        SELECT id FROM invitations
+        WHERE lower(email) = lower($1) AND accepted_at IS NULL AND revoked_at IS NULL
+          AND expires_at > CURRENT_TIMESTAMP
+    `,
+	Revoke: `
+        UPDATE invitations
+        SET revoked_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND accepted_at IS NULL AND revoked_at IS NULL
+    `,
+	// Replaces the token so earlier links stop working
+	Renew: `
+        UPDATE invitations
+        SET token_hash = $1, expires_at = $2
+        WHERE id = $3 AND accepted_at IS NULL AND revoked_at IS NULL
+    `,
+	MarkAccepted: `
+        UPDATE invitations
+        SET accepted_at = CURRENT_TIMESTAMP, accepted_user_id = $1
+        WHERE id = $2
+    `,
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+)
+
+type DbLoginAttempt struct {
+	ID        int       `json:"id" db:"id"`
+	Username  string    `json:"user_name" db:"user_name"`
+	IPAddress string    `json:"ip_address" db:"ip_address"`
+	Success   bool      `json:"success" db:"success"`
+	Reason    string    `json:"reason" db:"reason"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type LAQueries struct {
+	InsertAttempt     string
+	GetAttempts       string
+	GetLockRemaining  string
+	IncrementThrottle string
+	LockThrottle      string
+	DeleteThrottle    string
+}
+
+var LoginAttemptQueries = LAQueries{
+	InsertAttempt: `
+        INSERT INTO login_attempts (user_name, ip_address, success, reason)
+        VALUES ($1, $2, $3, $4)
+    `,
+	GetAttempts: `
+        SELECT id, user_name, ip_address, success, reason, created_at
+        FROM login_attempts
+        WHERE ($1 = '' OR lower(user_name) = lower($1))
+          AND ($2 = '' OR ip_address = $2)
+          AND ($3 = false OR success = false)
+        ORDER BY created_at DESC
+        LIMIT $4
+    `,
+	GetLockRemaining: `
+        SELECT COALESCE(EXTRACT(EPOCH FROM MAX(locked_until) - CURRENT_TIMESTAMP), 0)::float8
+        FROM login_throttles
+        WHERE throttle_key = ANY($1) AND locked_until > CURRENT_TIMESTAMP
+    `,
+	IncrementThrottle: `
+        INSERT INTO login_throttles (throttle_key, failed_count, last_failed_at)
+        VALUES ($1, 1, CURRENT_TIMESTAMP)
+        ON CONFLICT (throttle_key) DO UPDATE
+        SET failed_count = CASE
+                WHEN login_throttles.last_failed_at < CURRENT_TIMESTAMP - $2::interval THEN 1
+                ELSE login_throttles.failed_count + 1
+            END,
+            last_failed_at = CURRENT_TIMESTAMP
+        RETURNING failed_count
+    `,
+	LockThrottle: `
+        UPDATE login_throttles
+        SET locked_until = CURRENT_TIMESTAMP + $2::interval
+        WHERE throttle_key = $1
+    `,
+	DeleteThrottle: `
+        DELETE FROM login_throttles
+        WHERE throttle_key = $1
+    `,
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+)
+
+// DbMagicLink is a single-use sign-in link. NonceHash binds it to the
+// browser that asked for it.
+type DbMagicLink struct {
+	ID        int        `json:"id" db:"id"`
+	UserID    int        `json:"user_id" db:"user_id"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	NonceHash string     `json:"-" db:"nonce_hash"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	UsedAt    *time.Time `json:"used_at" db:"used_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+type MLQueries struct {
+	Insert             string
+	GetByHashForUpdate string
+	MarkUsed           string
+	InvalidateForUser  string
+}
+
+var MagicLinkQueries = MLQueries{
+	Insert: `
+        INSERT INTO magic_links (user_id, token_hash, nonce_hash, expires_at)
+        VALUES ($1, $2, $3, $4)
+    `,
+	GetByHashForUpdate: `
+        SELECT id, user_id, token_hash, nonce_hash, expires_at, used_at, created_at
+        FROM magic_links
+        WHERE token_hash = $1
+        FOR UPDATE
+    `,
+	MarkUsed: `
+        UPDATE magic_links
+        SET used_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+    `,
+	InvalidateForUser: `
+        UPDATE magic_links
+        SET used_at = CURRENT_TIMESTAMP
+        WHERE user_id = $1 AND used_at IS NULL
+    `,
+}
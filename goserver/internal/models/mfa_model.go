@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+)
+
+type DbUserMFA struct {
+	UserID       int        `json:"user_id" db:"user_id"`
+	TOTPSecret   string     `json:"-" db:"totp_secret"`
+	Enabled      bool       `json:"enabled" db:"enabled"`
+	LastUsedStep int64      `json:"-" db:"last_used_step"`
+	ConfirmedAt  *time.Time `json:"confirmed_at" db:"confirmed_at"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+}
+
+type MQueries struct {
+	GetByUserID         string
+	UpsertPending       string
+	Enable              string
+	Disable             string
+	AdvanceStep         string
+	DeleteRecoveryCodes string
+	InsertRecoveryCode  string
+	UseRecoveryCode     string
+}
+
+var MFAQueries = MQueries{
+	GetByUserID: `
+        SELECT user_id, totp_secret, enabled, last_used_step, confirmed_at, created_at
+        FROM user_mfa
+        WHERE user_id = $1
+    `,
+	UpsertPending: `
+        INSERT INTO user_mfa (user_id, totp_secret, enabled)
+        VALUES ($1, $2, false)
+        ON CONFLICT (user_id) DO UPDATE
+        SET totp_secret = EXCLUDED.totp_secret, enabled = false, last_used_step = 0, confirmed_at = NULL
+        WHERE user_mfa.enabled = false
+    `,
+	Enable: `
+        UPDATE user_mfa
+        SET enabled = true, confirmed_at = CURRENT_TIMESTAMP, last_used_step = $2
+        WHERE user_id = $1
+    `,
+	Disable: `
+        DELETE FROM user_mfa
+        WHERE user_id = $1
+    `,
+	AdvanceStep: `
+        UPDATE user_mfa
+        SET last_used_step = $2
+        WHERE user_id = $1 AND last_used_step < $2
+    `,
+	DeleteRecoveryCodes: `
+        DELETE FROM mfa_recovery_codes
+        WHERE user_id = $1
+    `,
+	InsertRecoveryCode: `
+        INSERT INTO mfa_recovery_codes (user_id, code_hash)
+        VALUES ($1, $2)
+    `,
+	UseRecoveryCode: `
+        UPDATE mfa_recovery_codes
+        SET used_at = CURRENT_TIMESTAMP
+        WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+    `,
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+)
+
+type DbPasswordReset struct {
+	ID        int        `json:"id" db:"id"`
+	UserID    int        `json:"user_id" db:"user_id"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	UsedAt    *time.Time `json:"used_at" db:"used_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+type PRQueries struct {
+	Insert             string
+	GetByHashForUpdate string
+	MarkUsed           string
+	InvalidateForUser  string
+}
+
+var PasswordResetQueries = PRQueries{
+	Insert: `
+        INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+        VALUES ($1, $2, $3)
+    `,
+	GetByHashForUpdate: `
+        SELECT id, user_id, token_hash, expires_at, used_at, created_at
+        FROM password_reset_tokens
+        WHERE token_hash = $1
+        FOR UPDATE
+    `,
+	MarkUsed: `
+        UPDATE password_reset_tokens
+        SET used_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+    `,
+	InvalidateForUser: `
+        UPDATE password_reset_tokens
+        SET used_at = CURRENT_TIMESTAMP
+        WHERE user_id = $1 AND used_at IS NULL
+    `,
+}
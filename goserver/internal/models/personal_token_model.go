@@ -0,0 +1,77 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Scopes a personal access token can be granted. Browser sessions implicitly
+// hold all of them.
+const (
+	ScopeFilesRead     = "files:read"
+	ScopeBlogRead      = "blog:read"
+	ScopeBlogWrite     = "blog:write"
+	ScopeCommentsWrite = "comments:write"
+)
+
+var TOKEN_SCOPES = []string{ScopeFilesRead, ScopeBlogRead, ScopeBlogWrite, ScopeCommentsWrite}
+
+// DbPersonalAccessToken is a long-lived credential for scripts. Only the
+// SHA-256 of the token is stored; Prefix lets users tell tokens apart.
+type DbPersonalAccessToken struct {
+	ID         int            `json:"id" db:"id"`
+	UserID     int            `json:"user_id" db:"user_id"`
+	Name       string         `json:"name" db:"token_name"`
+	Prefix     string         `json:"prefix" db:"token_prefix"`
+	TokenHash  string         `json:"-" db:"token_hash"`
+	Scopes     pq.StringArray `json:"scopes" db:"scopes"`
+	ExpiresAt  time.Time      `json:"expires_at" db:"expires_at"`
+	LastUsedAt *time.Time     `json:"last_used_at" db:"last_used_at"`
+	RevokedAt  *time.Time     `json:"revoked_at" db:"revoked_at"`
+	CreatedAt  time.Time      `json:"created_at" db:"created_at"`
+}
+
+type PATQueries struct {
+	Insert      string
+	GetByUserID string
+	GetByHash   string
+	TouchUsed   string
+	Revoke      string
+	RevokeAll   string
+}
+
+var PersonalTokenQueries = PATQueries{
+	Insert: `
+        INSERT INTO personal_access_tokens (user_id, token_name, token_prefix, token_hash, scopes, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at
+    `,
+	GetByUserID: `
+        SELECT id, user_id, token_name, token_prefix, token_hash, scopes, expires_at, last_used_at, revoked_at, created_at
+        FROM personal_access_tokens
+        WHERE user_id = $1
+        ORDER BY created_at DESC
+    `,
+	GetByHash: `
+        SELECT id, user_id, token_name, token_prefix, token_hash, scopes, expires_at, last_used_at, revoked_at, created_at
+        FROM personal_access_tokens
+        WHERE token_hash = $1
+    `,
+	// Only written once a minute per token to keep busy scripts cheap
+	TouchUsed: `
+        UPDATE personal_access_tokens
+        SET last_used_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute')
+    `,
+	Revoke: `
+        UPDATE personal_access_tokens
+        SET revoked_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+    `,
+	RevokeAll: `
+        UPDATE personal_access_tokens
+        SET revoked_at = CURRENT_TIMESTAMP
+        WHERE user_id = $1 AND revoked_at IS NULL
+    `,
+}
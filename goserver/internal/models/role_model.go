@@ -0,0 +1,111 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Permissions checked by middleware.RequirePermission
+const (
+	PermBlogCreate       = "blog.create"
+	PermBlogManage       = "blog.manage"
+	PermCommentCreate    = "comment.create"
+	PermCommentModerate  = "comment.moderate"
+	PermFilesDownload    = "files.download"
+	PermPlacesWrite      = "places.write"
+	PermUsersManage      = "users.manage"
+	PermRolesManage      = "roles.manage"
+	PermUsersImpersonate = "users.impersonate"
+)
+
+// PERMISSIONS lists every permission the code checks, with a description
+// shown in the admin UI. New entries are added to the database at startup.
+var PERMISSIONS = []DbPermission{
+	{Name: PermBlogCreate, Description: "Write blog posts"},
+	{Name: PermBlogManage, Description: "Edit and delete anyone's blog posts"},
+	{Name: PermCommentCreate, Description: "Comment on blog posts"},
+	{Name: PermCommentModerate, Description: "Edit and delete comments"},
+	{Name: PermFilesDownload, Description: "Download manuals"},
+	{Name: PermPlacesWrite, Description: "Add, edit and delete places on the map"},
+	{Name: PermUsersManage, Description: "Manage user accounts"},
+	{Name: PermUsersImpersonate, Description: "View the site as another user, read-only"},
+	{Name: PermRolesManage, Description: "Manage roles and their permissions"},
+}
+
+type DbPermission struct {
+	Name        string `json:"name" db:"permission_name"`
+	Description string `json:"description" db:"description"`
+}
+
+type DbRole struct {
+	ID          int            `json:"id" db:"id"`
+	Name        string         `json:"name" db:"role_name"`
+	Level       int            `json:"level" db:"role_level"`
+	RequireMFA  bool           `json:"require_mfa" db:"require_mfa"`
+	Description string         `json:"description" db:"description"`
+	Permissions pq.StringArray `json:"permissions" db:"permissions"`
+	CreatedAt   time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+type RLQueries struct {
+	GetAll           string
+	GetPermissions   string
+	InsertPermission string
+	Insert           string
+	Update           string
+	Delete           string
+	CountUsers       string
+	ClearPermissions string
+	GrantPermission  string
+}
+
+var RoleQueries = RLQueries{
+	GetAll: `
+        SELECT r.id, r.role_name, r.role_level, r.require_mfa, r.description, r.created_at, r.updated_at,
+               COALESCE(array_agg(rp.permission_name ORDER BY rp.permission_name)
+                        FILTER (WHERE rp.permission_name IS NOT NULL), '{}') AS permissions
+        FROM roles r
+        LEFT JOIN role_permissions rp ON rp.role_id = r.id
+        GROUP BY r.id
+        ORDER BY r.role_level, r.role_name
+    `,
+	GetPermissions: `
+        SELECT permission_name, description
+        FROM permissions
+        ORDER BY permission_name
+    `,
+	InsertPermission: `
+        INSERT INTO permissions (permission_name, description)
+        VALUES ($1, $2)
+        ON CONFLICT (permission_name) DO NOTHING
+        RETURNING permission_name
+    `,
+	Insert: `
+        INSERT INTO roles (role_name, role_level, require_mfa, description)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (role_name) DO NOTHING
+        RETURNING id
+    `,
+	Update: `
+        UPDATE roles
+        SET role_level = $1, require_mfa = $2, description = $3, updated_at = CURRENT_TIMESTAMP
+        WHERE role_name = $4
+        RETURNING id
+    `,
+	Delete: `
+        DELETE FROM roles WHERE role_name = $1
+    `,
+	CountUsers: `
+        SELECT COUNT(*) FROM users WHERE user_role = $1
+    `,
+	ClearPermissions: `
+        DELETE FROM role_permissions WHERE role_id = $1
+    `,
+	GrantPermission: `
+        INSERT INTO role_permissions (role_id, permission_name)
+        SELECT id, $2 FROM roles WHERE role_name = $1
+        ON CONFLICT DO NOTHING
+    `,
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+)
+
+// Role request statuses
+const (
+	RoleRequestPending  = "pending"
+	RoleRequestApproved = "approved"
+	RoleRequestDenied   = "denied"
+)
+
+// DbRoleRequest is a user's request to be moved to a higher role. Username
+// and Email are joined from users for the admin queue.
+type DbRoleRequest struct {
+	ID            int        `json:"id" db:"id"`
+	UserID        int        `json:"user_id" db:"user_id"`
+	Username      string     `json:"user_name" db:"user_name"`
+	Email         string     `json:"user_email" db:"user_email"`
+	CurrentRole   string     `json:"current_role" db:"user_role"`
+	RequestedRole string     `json:"requested_role" db:"requested_role"`
+	Note          string     `json:"note" db:"note"`
+	Status        string     `json:"status" db:"status"`
+	ReviewerID    *int       `json:"reviewer_id" db:"reviewer_id"`
+	ReviewNote    string     `json:"review_note" db:"review_note"`
+	ReviewedAt    *time.Time `json:"reviewed_at" db:"reviewed_at"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+}
+
+type RRQueries struct {
+	Insert           string
+	GetByUserID      string
+	GetByStatus      string
+	GetByIDForUpdate string
+	Review           string
+}
+
+const roleRequestColumns = `
+        SELECT rr.id, rr.user_id, u.user_name, u.user_email, u.user_role, rr.requested_role, rr.note,
+               rr.status, rr.reviewer_id, rr.review_note, rr.reviewed_at, rr.created_at
+        FROM role_requests rr
+        JOIN users u ON u.id = rr.user_id`
+
+var RoleRequestQueries = RRQueries{
+	Insert: `
+        INSERT INTO role_requests (user_id, requested_role, note)
+        VALUES ($1, $2, $3)
+        RETURNING id, status, created_at
+    `,
+	GetByUserID: roleRequestColumns + `
+        WHERE rr.user_id = $1
+        ORDER BY rr.created_at DESC
+    `,
+	GetByStatus: roleRequestColumns + `
+        WHERE $1 = '' OR rr.status = $1
+        ORDER BY rr.created_at ASC
+    `,
+	GetByIDForUpdate: roleRequestColumns + `
+        WHERE rr.id = $1
+        FOR UPDATE OF rr, u
+    `,
+	Review: `
+        UPDATE role_requests
+        SET status = $1, reviewer_id = $2, review_note = $3, reviewed_at = CURRENT_TIMESTAMP
+        WHERE id = $4
+    `,
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"time"
+)
+
+// DbUserSession is one signed-in device. Its ID is the refresh token family,
+// and access tokens carry it in their "sid" claim.
+type DbUserSession struct {
+	ID         string     `json:"id" db:"family_id"`
+	UserID     int        `json:"user_id" db:"user_id"`
+	DeviceID   string     `json:"device_id" db:"device_id"`
+	UserAgent  string     `json:"user_agent" db:"user_agent"`
+	IPAddress  string     `json:"ip_address" db:"ip_address"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	LastUsedAt time.Time  `json:"last_used_at" db:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at" db:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	Current    bool       `json:"current" db:"-"`
+}
+
+type SQueries struct {
+	Insert               string
+	Touch                string
+	GetActiveByUser      string
+	GetRevokedSince      string
+	Revoke               string
+	RevokeDevice         string
+	RevokeOthers         string
+	RevokeAllForUser     string
+	RevokeByRefreshToken string
+}
+
+var SessionQueries = SQueries{
+	Insert: `
+        INSERT INTO user_sessions (family_id, user_id, device_id, user_agent, ip_address, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+    `,
+	Touch: `
+        UPDATE user_sessions
+        SET user_agent = $1, ip_address = $2, expires_at = $3, last_used_at = CURRENT_TIMESTAMP
+        WHERE family_id = $4
+    `,
+	GetActiveByUser: `
+        SELECT family_id, user_id, device_id, user_agent, ip_address, expires_at, last_used_at, revoked_at, created_at
+        FROM user_sessions
+        WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+        ORDER BY last_used_at DESC
+    `,
+	GetRevokedSince: `
+        SELECT family_id, revoked_at
+        FROM user_sessions
+        WHERE revoked_at > $1
+    `,
+	Revoke: `
+        UPDATE user_sessions
+        SET revoked_at = CURRENT_TIMESTAMP
+        WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL
+        RETURNING family_id
+    `,
+	RevokeDevice: `
+        UPDATE user_sessions
+        SET revoked_at = CURRENT_TIMESTAMP
+        WHERE user_id = $1 AND device_id = $2 AND device_id <> '' AND revoked_at IS NULL
+        RETURNING family_id
+    `,
+	RevokeOthers: `
+        UPDATE user_sessions
+        SET revoked_at = CURRENT_TIMESTAMP
+        WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL
+        RETURNING family_id
+    `,
+	RevokeAllForUser: `
+        UPDATE user_sessions
+        SET revoked_at = CURRENT_TIMESTAMP
+        WHERE user_id = $1 AND revoked_at IS NULL
+    `,
+	RevokeByRefreshToken: `
+        UPDATE user_sessions
+        SET revoked_at = CURRENT_TIMESTAMP
+        WHERE family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = $1)
+          AND revoked_at IS NULL
+        RETURNING family_id
+    `,
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"time"
+)
+
+type DbRefreshToken struct {
+	ID        int        `json:"id" db:"id"`
+	UserID    int        `json:"user_id" db:"user_id"`
+	FamilyID  string     `json:"family_id" db:"family_id"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	DeviceID  string     `json:"device_id" db:"device_id"`
+	UserAgent string     `json:"user_agent" db:"user_agent"`
+	IPAddress string     `json:"ip_address" db:"ip_address"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	RotatedAt *time.Time `json:"rotated_at" db:"rotated_at"`
+	RevokedAt *time.Time `json:"revoked_at" db:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+type RTQueries struct {
+	Insert             string
+	GetByHashForUpdate string
+	MarkRotated        string
+	RevokeFamily       string
+	RevokeDevice       string
+	RevokeAllForUser   string
+	RevokeFamilyOf     string
+	RevokeOthers       string
+	GetOwner           string
+}
+
+var RefreshTokenQueries = RTQueries{
+	Insert: `
+        INSERT INTO refresh_tokens (user_id, family_id, token_hash, device_id, user_agent, ip_address, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id, created_at
+    `,
+	GetByHashForUpdate: `
+        SELECT id, user_id, family_id, token_hash, device_id, user_agent, ip_address,
+               expires_at, rotated_at, revoked_at, created_at
+        FROM refresh_tokens
+        WHERE token_hash = $1
+        FOR UPDATE
+    `,
+	MarkRotated: `
+        UPDATE refresh_tokens
+        SET rotated_at = CURRENT_TIMESTAMP
+        WHERE id = $1
+    `,
+	RevokeFamily: `
+        UPDATE refresh_tokens
+        SET revoked_at = CURRENT_TIMESTAMP
+        WHERE family_id = $1 AND revoked_at IS NULL
+    `,
+	RevokeDevice: `
+        UPDATE refresh_tokens
+        SET revoked_at = CURRENT_TIMESTAMP
+        WHERE user_id = $1 AND device_id = $2 AND device_id <> '' AND revoked_at IS NULL
+    `,
+	RevokeAllForUser: `
+        UPDATE refresh_tokens
+        SET revoked_at = CURRENT_TIMESTAMP
+        WHERE user_id = $1 AND revoked_at IS NULL
+    `,
+	RevokeFamilyOf: `
+        UPDATE refresh_tokens
+        SET revoked_at = CURRENT_TIMESTAMP
+        WHERE family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = $1)
+          AND revoked_at IS NULL
+    `,
+	RevokeOthers: `
+        UPDATE refresh_tokens
+        SET revoked_at = CURRENT_TIMESTAMP
+        WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL
+    `,
+	GetOwner: `
+        SELECT user_id FROM refresh_tokens WHERE token_hash = $1
+    `,
+}
+
+type DbRevokedToken struct {
+	JTI       string    `json:"jti" db:"jti"`
+	UserID    int       `json:"user_id" db:"user_id"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	RevokedAt time.Time `json:"revoked_at" db:"revoked_at"`
+}
+
+type DbUserTokenRevocation struct {
+	UserID        int       `json:"user_id" db:"user_id"`
+	RevokedBefore time.Time `json:"revoked_before" db:"revoked_before"`
+}
+
+type RVQueries struct {
+	InsertToken     string
+	GetActiveTokens string
+	PurgeExpired    string
+	UpsertUser      string
+	GetUsers        string
+}
+
+var RevocationQueries = RVQueries{
+	InsertToken: `
+        INSERT INTO revoked_tokens (jti, user_id, expires_at)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (jti) DO NOTHING
+    `,
+	GetActiveTokens: `
+        SELECT jti, user_id, expires_at, revoked_at
+        FROM revoked_tokens
+        WHERE expires_at > CURRENT_TIMESTAMP
+    `,
+	PurgeExpired: `
+        DELETE FROM revoked_tokens
+        WHERE expires_at <= CURRENT_TIMESTAMP
+    `,
+	UpsertUser: `
+        INSERT INTO user_token_revocations (user_id, revoked_before)
+        VALUES ($1, $2)
+        ON CONFLICT (user_id) DO UPDATE SET revoked_before = EXCLUDED.revoked_before
+    `,
+	GetUsers: `
+        SELECT user_id, revoked_before
+        FROM user_token_revocations
+    `,
+}
@@ -4,45 +4,87 @@ import (
 	"time"
 )
 
+// UserRole describes one of the built-in roles. They are seeded into the
+// roles table on first start; after that the database is authoritative and
+// admins manage roles through /admin/roles.
 type UserRole struct {
-	Name  string
-	Level int
+	Name        string
+	Level       int
+	RequireMFA  bool
+	Permissions []string
 }
 
 var USER_ROLES = map[string]UserRole{
-	"USER":      {Name: "User", Level: 1},
-	"MANUALS":   {Name: "Manuals", Level: 2},
-	"COMMENTOR": {Name: "Commentor", Level: 3},
-	"CREATOR":   {Name: "Creator", Level: 4},
-	"ADMIN":     {Name: "Admin", Level: 5},
+	"USER":    {Name: "User", Level: 1},
+	"MANUALS": {Name: "Manuals", Level: 2, Permissions: []string{PermFilesDownload}},
+	"COMMENTOR": {Name: "Commentor", Level: 3, Permissions: []string{
+		PermFilesDownload, PermCommentCreate,
+	}},
+	"CREATOR": {Name: "Creator", Level: 4, RequireMFA: true, Permissions: []string{
+		PermFilesDownload, PermCommentCreate, PermCommentModerate, PermBlogCreate, PermPlacesWrite,
+	}},
+	"ADMIN": {Name: "Admin", Level: 5, RequireMFA: true, Permissions: []string{
+		PermFilesDownload, PermCommentCreate, PermCommentModerate, PermBlogCreate, PermBlogManage,
+		PermPlacesWrite, PermUsersManage, PermRolesManage, PermUsersImpersonate,
+	}},
 }
 
 // PostgreSQL structures
 type DbUser struct {
 	ID            int       `json:"id" db:"id"`
 	Username      string    `json:"user_name" db:"user_name"`
-	Password      string    `json:"user_password" db:"user_password"`
+	Password      string    `json:"-" db:"user_password"`
 	Email         string    `json:"user_email" db:"user_email"`
 	Role          string    `json:"user_role" db:"user_role"`
-	VerifyCode    string    `json:"user_verify_code" db:"user_verify_code"`
+	VerifyCode    string    `json:"-" db:"user_verify_code"`
 	VerifyExpires time.Time `json:"user_verify_expires" db:"user_verify_expires"`
 	Approved      bool      `json:"user_approved" db:"user_approved"`
 	CreatedAt     time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
 }
 
+// PublicUser is what anyone may see about an account
+type PublicUser struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"user_name"`
+	Role      string    `json:"user_role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Public returns the user's public projection, without email or secrets
+func (u *DbUser) Public() PublicUser {
+	return PublicUser{ID: u.ID, Username: u.Username, Role: u.Role, CreatedAt: u.CreatedAt}
+}
+
+// SignupRequest is the only shape accepted from anonymous callers creating an
+// account. Role and approval are always decided by the server.
+type SignupRequest struct {
+	Username string `json:"user_name"`
+	Email    string `json:"user_email"`
+	Password string `json:"user_password"`
+}
+
 type UQueries struct {
 	GetAll                 string
 	GetByID                string
 	GetByName              string
+	GetByEmail             string
 	CheckExists            string
 	Insert                 string
 	Update                 string
 	UpdatePassword         string
+	RehashPassword         string
 	Delete                 string
 	Authenticate           string
+	ResolveLoginName       string
 	FindByVerificationCode string
 	ApproveUser            string
+	ResetVerifyCode        string
+	InsertApproved         string
+	GetByRoles             string
+	UpdateRole             string
+	UpdateEmail            string
+	UpdateUsername         string
 }
 
 var UserQueries = UQueries{
@@ -61,6 +103,11 @@ var UserQueries = UQueries{
       FROM users 
       WHERE user_name = $1
 		`,
+	GetByEmail: `
+			SELECT id, user_name, user_email, user_role, user_approved, created_at, updated_at 
+      FROM users 
+      WHERE lower(user_email) = lower($1)
+		`,
 	CheckExists: `
 			SELECT id FROM users WHERE user_name = $1 OR user_email = $2
 		`,
@@ -79,6 +126,12 @@ var UserQueries = UQueries{
 			UPDATE users 
       SET user_password = $1, updated_at = CURRENT_TIMESTAMP
       WHERE id = $2
+	`,
+	// Only replaces the hash that was verified, so a concurrent password change wins
+	RehashPassword: `
+			UPDATE users 
+      SET user_password = $1
+      WHERE id = $2 AND user_password = $3
 	`,
 	Delete: `
 			DELETE FROM users WHERE id = $1
@@ -87,6 +140,14 @@ var UserQueries = UQueries{
       SELECT id, user_name, user_password, user_email, user_role, created_at, updated_at 
       FROM users 
       WHERE user_name = $1
+	`,
+	// The account a login identifier refers to, picked the same way as GetUser
+	ResolveLoginName: `
+      SELECT user_name
+      FROM users 
+      WHERE user_name = $1 OR lower(user_email) = lower($1)
+      ORDER BY (user_name = $1) DESC
+      LIMIT 1
 	`,
 	FindByVerificationCode: `
 			SELECT id, user_name, user_password, user_email, user_role, 
@@ -103,4 +164,37 @@ var UserQueries = UQueries{
         WHERE id = $1
         RETURNING updated_at
     `,
+	ResetVerifyCode: `
+      UPDATE users 
+        SET user_verify_code = $1, 
+            user_verify_expires = $2, 
+            updated_at = CURRENT_TIMESTAMP
+        WHERE id = $3 AND user_approved = false
+    `,
+	InsertApproved: `
+			INSERT INTO users (user_name, user_password, user_email, user_role, user_approved) 
+      VALUES ($1, $2, $3, $4, true) 
+      RETURNING id, created_at, updated_at
+    `,
+	GetByRoles: `
+			SELECT id, user_name, user_email, user_role, created_at, updated_at 
+      FROM users 
+      WHERE user_role = ANY($1)
+    `,
+	UpdateRole: `
+			UPDATE users 
+      SET user_role = $1, updated_at = CURRENT_TIMESTAMP
+      WHERE id = $2
+    `,
+	UpdateEmail: `
+			UPDATE users 
+      SET user_email = $1, updated_at = CURRENT_TIMESTAMP
+      WHERE id = $2
+    `,
+	UpdateUsername: `
+			UPDATE users 
+      SET user_name = $1, updated_at = CURRENT_TIMESTAMP
+      WHERE id = $2
+      RETURNING updated_at
+    `,
 }
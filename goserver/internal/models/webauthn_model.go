@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+)
+
+// DbWebAuthnCredential stores one registered passkey. CredentialData holds
+// the library's credential record as JSON.
+type DbWebAuthnCredential struct {
+	ID             int        `json:"id" db:"id"`
+	UserID         int        `json:"user_id" db:"user_id"`
+	CredentialID   string     `json:"credential_id" db:"credential_id"`
+	Name           string     `json:"credential_name" db:"credential_name"`
+	CredentialData string     `json:"-" db:"credential_data"`
+	LastUsedAt     *time.Time `json:"last_used_at" db:"last_used_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+
+type WAQueries struct {
+	GetByUserID string
+	Insert      string
+	UpdateData  string
+	Delete      string
+}
+
+var WebAuthnQueries = WAQueries{
+	GetByUserID: `
+        SELECT id, user_id, credential_id, credential_name, credential_data, last_used_at, created_at
+        FROM webauthn_credentials
+        WHERE user_id = $1
+        ORDER BY created_at ASC
+    `,
+	Insert: `
+        INSERT INTO webauthn_credentials (user_id, credential_id, credential_name, credential_data)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, created_at
+    `,
+	UpdateData: `
+        UPDATE webauthn_credentials
+        SET credential_data = $1, last_used_at = CURRENT_TIMESTAMP
+        WHERE credential_id = $2
+    `,
+	Delete: `
+        DELETE FROM webauthn_credentials
+        WHERE id = $1 AND user_id = $2
+    `,
+}
@@ -4,8 +4,10 @@ import (
 	"goserver/internal/config"
 	"goserver/internal/handlers"
 	"goserver/internal/middleware"
+	"goserver/internal/models"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,8 +19,9 @@ func SetupRouter() *gin.Engine {
 	// CORS middleware
 	router.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", cfg.FrontendURL)
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-Auth-Mode, X-CSRF-Token, If-Match")
+		c.Header("Access-Control-Expose-Headers", "ETag")
 		c.Header("Access-Control-Allow-Credentials", "true")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -32,7 +35,7 @@ func SetupRouter() *gin.Engine {
 		log.Printf("Request: %s %s", c.Request.Method, c.Request.URL.Path)
 		log.Printf("Origin: %s", c.Request.Header.Get("Origin"))
 		c.Next()
-		log.Printf("Response headers: %+v", c.Writer.Header())
+		log.Printf("Response headers: %+v", redactHeaders(c.Writer.Header()))
 	})
 
 	router.Use(middleware.Logger())
@@ -45,46 +48,138 @@ func SetupRouter() *gin.Engine {
 			apiRoutes.POST("/login", authHandler.Login)
 			apiRoutes.POST("/signup", authHandler.Signup)
 			apiRoutes.POST("/logout", authHandler.Logout)
-			//apiRoutes.POST("/refresh", authHandler.RefreshToken)
-			//apiRoutes.POST("/resend-verification", authHandler.ResendVerificationEmail)
+			apiRoutes.POST("/refresh", authHandler.RefreshToken)
+			apiRoutes.POST("/forgot-password", authHandler.ForgotPassword)
+			apiRoutes.POST("/reset-password", authHandler.ResetPassword)
+			apiRoutes.POST("/resend-verification", authHandler.ResendVerificationEmail)
+			apiRoutes.POST("/magic-link", authHandler.RequestMagicLink)
+			apiRoutes.POST("/magic-link/redeem", authHandler.RedeemMagicLink)
+		}
+
+		impersonationHandler := handlers.NewImpersonationHandler()
+		api.POST("/auth/impersonation/end", middleware.RequireAuth(), impersonationHandler.End)
+
+		mfaHandler := handlers.NewMFAHandler()
+		mfaRoutes := api.Group("/auth/mfa")
+		{
+			mfaRoutes.POST("/verify", mfaHandler.Verify)
+			mfaRoutes.POST("/enroll", middleware.OptionalAuth(), mfaHandler.Enroll)
+			mfaRoutes.POST("/enroll/confirm", middleware.OptionalAuth(), mfaHandler.ConfirmEnrollment)
+			mfaRoutes.POST("/disable", middleware.RequireAuth(), mfaHandler.Disable)
+		}
+
+		passkeyHandler := handlers.NewPasskeyHandler()
+		passkeyRoutes := api.Group("/auth/passkeys")
+		{
+			passkeyRoutes.GET("/", middleware.RequireAuth(), passkeyHandler.List)
+			passkeyRoutes.DELETE("/:id", middleware.RequireAuth(), passkeyHandler.Delete)
+			passkeyRoutes.POST("/register/begin", middleware.RequireAuth(), passkeyHandler.BeginRegistration)
+			passkeyRoutes.POST("/register/finish", middleware.RequireAuth(), passkeyHandler.FinishRegistration)
+			passkeyRoutes.POST("/login/begin", passkeyHandler.BeginLogin)
+			passkeyRoutes.POST("/login/finish", passkeyHandler.FinishLogin)
+		}
+
+		invitationHandler := handlers.NewInvitationHandler()
+		invitationRoutes := api.Group("/auth/invitation")
+		{
+			invitationRoutes.POST("", invitationHandler.Lookup)
+			invitationRoutes.POST("/accept", invitationHandler.Accept)
+		}
+
+		oidcHandler := handlers.NewOIDCHandler()
+		oidcRoutes := api.Group("/auth/oidc")
+		{
+			oidcRoutes.GET("/providers", oidcHandler.Providers)
+			oidcRoutes.GET("/:provider/login", oidcHandler.Login)
+			oidcRoutes.GET("/:provider/callback", oidcHandler.Callback)
+			oidcRoutes.POST("/:provider/link", middleware.RequireAuth(), oidcHandler.Link)
 		}
 
 		blogHandler := handlers.NewBlogHandler()
 		blogRoutes := api.Group("/blog")
 		{
-			blogRoutes.GET("/", blogHandler.GetAll)
-			blogRoutes.GET("/:id", middleware.RequireAuth(), middleware.VerifyBlogExists(), middleware.VerifyBlogOwnership(), blogHandler.GetByID)
-			blogRoutes.POST("/", middleware.RequireAuth(), middleware.RequireRole("Creator", "Admin"), blogHandler.Create)
-			blogRoutes.DELETE("/:id", middleware.RequireAuth(), middleware.VerifyBlogExists(), middleware.VerifyBlogOwnership(), blogHandler.Delete)
+			blogRoutes.GET("/", middleware.OptionalAuth(models.ScopeBlogRead), blogHandler.GetAll)
+			blogRoutes.GET("/:id", middleware.RequireAuth(models.ScopeBlogRead), middleware.VerifyBlogExists(), middleware.VerifyBlogOwnership(), blogHandler.GetByID)
+			blogRoutes.POST("/", middleware.RequireAuth(models.ScopeBlogWrite), middleware.RequirePermission(models.PermBlogCreate), blogHandler.Create)
+			blogRoutes.PUT("/:id", middleware.RequireAuth(models.ScopeBlogWrite), middleware.VerifyBlogExists(), middleware.VerifyBlogOwnership(), blogHandler.Update)
+			blogRoutes.PATCH("/:id", middleware.RequireAuth(models.ScopeBlogWrite), middleware.VerifyBlogExists(), middleware.VerifyBlogOwnership(), blogHandler.Patch)
+			blogRoutes.DELETE("/:id", middleware.RequireAuth(models.ScopeBlogWrite), middleware.VerifyBlogExists(), middleware.VerifyBlogOwnership(), blogHandler.Delete)
+			blogRoutes.GET("/:id/revisions", middleware.RequireAuth(models.ScopeBlogRead), middleware.VerifyBlogExists(), middleware.VerifyBlogOwnership(), blogHandler.ListRevisions)
+			blogRoutes.GET("/:id/revisions/diff", middleware.RequireAuth(models.ScopeBlogRead), middleware.VerifyBlogExists(), middleware.VerifyBlogOwnership(), blogHandler.DiffRevisions)
+			blogRoutes.GET("/:id/revisions/:revision", middleware.RequireAuth(models.ScopeBlogRead), middleware.VerifyBlogExists(), middleware.VerifyBlogOwnership(), blogHandler.GetRevision)
+			blogRoutes.POST("/:id/revisions/:revision/restore", middleware.RequireAuth(models.ScopeBlogWrite), middleware.VerifyBlogExists(), middleware.VerifyBlogOwnership(), blogHandler.RestoreRevision)
 		}
 
 		commentHandler := handlers.NewCommentHandler()
 		commentRoutes := api.Group("/comments")
 		{
 			commentRoutes.GET("/:blogId", commentHandler.GetByBlogID)
-			commentRoutes.POST("/:blogId", middleware.RequireAuth(), middleware.RequireRole("Commentor", "Creator", "Admin"), commentHandler.Create)
-			commentRoutes.PUT("/:blogId/:id", middleware.RequireAuth(), middleware.RequireRole("Creator", "Admin"), commentHandler.Update)
-			commentRoutes.DELETE("/:blogId/:id", middleware.RequireAuth(), middleware.RequireRole("Creator", "Admin"), commentHandler.Delete)
+			commentRoutes.POST("/:blogId", middleware.RequireAuth(models.ScopeCommentsWrite), middleware.RequirePermission(models.PermCommentCreate), commentHandler.Create)
+			commentRoutes.PUT("/:blogId/:id", middleware.RequireAuth(models.ScopeCommentsWrite), middleware.RequirePermission(models.PermCommentModerate), commentHandler.Update)
+			commentRoutes.DELETE("/:blogId/:id", middleware.RequireAuth(models.ScopeCommentsWrite), middleware.RequirePermission(models.PermCommentModerate), commentHandler.Delete)
 		}
 
 		userHandler := handlers.NewUserHandler()
 		userRoutes := api.Group("/users")
 		{
-			userRoutes.GET("/", middleware.RequireAuth(), middleware.RequireRole("Admin"), userHandler.GetAll)
-			userRoutes.GET("/:id", userHandler.GetByID)
-			userRoutes.POST("/", userHandler.Create)
+			userRoutes.GET("/", middleware.RequireAuth(), middleware.RequirePermission(models.PermUsersManage), userHandler.GetAll)
+			userRoutes.GET("/login-attempts", middleware.RequireAuth(), middleware.RequirePermission(models.PermUsersManage), userHandler.LoginAttempts)
+			userRoutes.GET("/me", middleware.RequireAuth(), userHandler.GetMe)
+			userRoutes.PATCH("/me", middleware.RequireAuth(), userHandler.UpdateMe)
+			userRoutes.DELETE("/me", middleware.RequireAuth(), userHandler.DeleteMe)
+			userRoutes.POST("/me/password", middleware.RequireAuth(), userHandler.ChangePassword)
+			userRoutes.GET("/me/export", middleware.RequireAuth(), userHandler.ExportMe)
+			userRoutes.GET("/:id", middleware.OptionalAuth(), userHandler.GetByID)
+			userRoutes.POST("/", authHandler.Signup)
 			userRoutes.POST("/verify-email/", userHandler.VerifyEmail)
-			userRoutes.PUT("/:id", middleware.RequireAuth(), middleware.RequireRole("Admin"), userHandler.Update)
-			userRoutes.DELETE("/:id", middleware.RequireAuth(), middleware.RequireRole("Admin"), userHandler.Delete)
+			userRoutes.PUT("/:id", middleware.RequireAuth(), middleware.RequirePermission(models.PermUsersManage), userHandler.Update)
+			userRoutes.DELETE("/:id", middleware.RequireAuth(), middleware.RequirePermission(models.PermUsersManage), userHandler.Delete)
+			userRoutes.POST("/:id/unlock", middleware.RequireAuth(), middleware.RequirePermission(models.PermUsersManage), userHandler.Unlock)
+		}
+
+		sessionHandler := handlers.NewSessionHandler()
+		sessionRoutes := api.Group("/users")
+		{
+			sessionRoutes.GET("/me/sessions", middleware.RequireAuth(), sessionHandler.ListMine)
+			sessionRoutes.DELETE("/me/sessions", middleware.RequireAuth(), sessionHandler.RevokeOthers)
+			sessionRoutes.DELETE("/me/sessions/:id", middleware.RequireAuth(), sessionHandler.RevokeMine)
+			sessionRoutes.GET("/:id/sessions", middleware.RequireAuth(), middleware.RequirePermission(models.PermUsersManage), sessionHandler.ListForUser)
+			sessionRoutes.DELETE("/:id/sessions", middleware.RequireAuth(), middleware.RequirePermission(models.PermUsersManage), sessionHandler.RevokeAllForUser)
+			sessionRoutes.DELETE("/:id/sessions/:sessionId", middleware.RequireAuth(), middleware.RequirePermission(models.PermUsersManage), sessionHandler.RevokeForUser)
+		}
+
+		emailChangeHandler := handlers.NewEmailChangeHandler()
+		emailChangeRoutes := api.Group("/users")
+		{
+			emailChangeRoutes.GET("/me/email", middleware.RequireAuth(), emailChangeHandler.Get)
+			emailChangeRoutes.POST("/me/email", middleware.RequireAuth(), emailChangeHandler.Start)
+			emailChangeRoutes.DELETE("/me/email", middleware.RequireAuth(), emailChangeHandler.CancelMine)
+			emailChangeRoutes.POST("/email/confirm", emailChangeHandler.Confirm)
+			emailChangeRoutes.POST("/email/cancel", emailChangeHandler.Cancel)
+		}
+
+		roleRequestHandler := handlers.NewRoleRequestHandler()
+		roleRequestRoutes := api.Group("/users/me/role-requests", middleware.RequireAuth())
+		{
+			roleRequestRoutes.GET("/", roleRequestHandler.ListMine)
+			roleRequestRoutes.POST("/", roleRequestHandler.Create)
+		}
+
+		personalTokenHandler := handlers.NewPersonalTokenHandler()
+		personalTokenRoutes := api.Group("/users/me/tokens", middleware.RequireAuth())
+		{
+			personalTokenRoutes.GET("/", personalTokenHandler.List)
+			personalTokenRoutes.POST("/", personalTokenHandler.Create)
+			personalTokenRoutes.DELETE("/:id", personalTokenHandler.Revoke)
 		}
 
 		placeHandler := handlers.NewPlaceHandler()
 		placeRoutes := router.Group("/api/v1/places")
 		{
 			placeRoutes.GET("/", placeHandler.GetPlaces)
-			placeRoutes.POST("/", placeHandler.SavePlace)
-			placeRoutes.PUT("/:id", placeHandler.UpdatePlace)
-			placeRoutes.DELETE("/:id", placeHandler.DeletePlace)
+			placeRoutes.POST("/", middleware.RequireAuth(), middleware.RequirePermission(models.PermPlacesWrite), placeHandler.SavePlace)
+			placeRoutes.PUT("/:id", middleware.RequireAuth(), middleware.RequirePermission(models.PermPlacesWrite), placeHandler.UpdatePlace)
+			placeRoutes.DELETE("/:id", middleware.RequireAuth(), middleware.RequirePermission(models.PermPlacesWrite), placeHandler.DeletePlace)
 		}
 
 		fileHandler := handlers.NewFileHandler()
@@ -94,17 +189,58 @@ func SetupRouter() *gin.Engine {
 			fileRoutes.GET("/", fileHandler.GetYearMakes)
 			fileRoutes.GET("/:yearMake", fileHandler.GetModels)
 			fileRoutes.GET("/:yearMake/:model", fileHandler.GetFiles)
-			fileRoutes.GET("/:yearMake/:model/download/:fileName", fileHandler.DownloadFile)
-			fileRoutes.GET("/:yearMake/:model/download/:fileName/:parentDir", fileHandler.DownloadFile)
-			fileRoutes.GET("/:yearMake/:model/download-directory/:dirName", fileHandler.DownloadDirectory)
-			fileRoutes.GET("/:yearMake/:model/download-all", fileHandler.DownloadAll)
-			fileRoutes.POST("/:yearMake/:model/download-selected", fileHandler.DownloadSelected)
+		}
+		downloadRoutes := fileRoutes.Group("/", middleware.RequireAuth(models.ScopeFilesRead), middleware.RequirePermission(models.PermFilesDownload))
+		{
+			downloadRoutes.GET("/:yearMake/:model/download/:fileName", fileHandler.DownloadFile)
+			downloadRoutes.GET("/:yearMake/:model/download/:fileName/:parentDir", fileHandler.DownloadFile)
+			downloadRoutes.GET("/:yearMake/:model/download-directory/:dirName", fileHandler.DownloadDirectory)
+			downloadRoutes.GET("/:yearMake/:model/download-all", fileHandler.DownloadAll)
+			downloadRoutes.POST("/:yearMake/:model/download-selected", fileHandler.DownloadSelected)
+		}
+
+		roleHandler := handlers.NewRoleHandler()
+		adminRoutes := api.Group("/admin", middleware.RequireAuth())
+		{
+			canManageRoles := middleware.RequirePermission(models.PermRolesManage)
+			adminRoutes.GET("/roles", canManageRoles, roleHandler.List)
+			adminRoutes.POST("/roles", canManageRoles, roleHandler.Create)
+			adminRoutes.PUT("/roles/:name", canManageRoles, roleHandler.Update)
+			adminRoutes.DELETE("/roles/:name", canManageRoles, roleHandler.Delete)
+			adminRoutes.GET("/permissions", canManageRoles, roleHandler.Permissions)
+
+			canManageUsers := middleware.RequirePermission(models.PermUsersManage)
+			adminRoutes.GET("/role-requests", canManageUsers, roleRequestHandler.List)
+			adminRoutes.POST("/role-requests/:id/approve", canManageUsers, roleRequestHandler.Approve)
+			adminRoutes.POST("/role-requests/:id/deny", canManageUsers, roleRequestHandler.Deny)
+			adminRoutes.GET("/invitations", canManageUsers, invitationHandler.List)
+			adminRoutes.POST("/invitations", canManageUsers, invitationHandler.Create)
+			adminRoutes.POST("/invitations/:id/resend", canManageUsers, invitationHandler.Resend)
+			adminRoutes.DELETE("/invitations/:id", canManageUsers, invitationHandler.Revoke)
+			adminRoutes.GET("/audit-log", canManageUsers, impersonationHandler.AuditLog)
+			adminRoutes.POST("/users/:id/impersonate", middleware.RequirePermission(models.PermUsersImpersonate), impersonationHandler.Start)
 		}
 	}
 
+	router.GET("/.well-known/jwks.json", handlers.NewAuthHandler().JWKS)
+
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
 
 	return router
 }
+
+// redactHeaders hides Set-Cookie values, which carry the session tokens in
+// cookie mode, before headers are logged
+func redactHeaders(header http.Header) http.Header {
+	if _, ok := header["Set-Cookie"]; !ok {
+		return header
+	}
+	redacted := header.Clone()
+	for i := range redacted["Set-Cookie"] {
+		name, _, _ := strings.Cut(redacted["Set-Cookie"][i], "=")
+		redacted["Set-Cookie"][i] = name + "=[redacted]"
+	}
+	return redacted
+}
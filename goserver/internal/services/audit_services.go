@@ -0,0 +1,29 @@
+package services
+
+import (
+	"fmt"
+	"log"
+
+	"goserver/internal/database"
+	"goserver/internal/models"
+)
+
+// RecordAudit writes an audit entry. Failures are logged rather than
+// returned so auditing never breaks the request being audited.
+func RecordAudit(entry *models.DbAuditEntry) {
+	_, err := database.DB.Exec(models.AuditQueries.Insert, entry.ActorID, entry.UserID, entry.Action,
+		entry.Method, entry.Path, entry.Status, entry.IPAddress, entry.SessionID, entry.Details)
+	if err != nil {
+		log.Printf("Failed to record audit entry %s: %v", entry.Action, err)
+	}
+}
+
+// GetAuditLog lists audit entries newest first. Zero IDs and an empty
+// session match everything.
+func GetAuditLog(actorID, userID int, sessionID string, limit int) ([]models.DbAuditEntry, error) {
+	entries := []models.DbAuditEntry{}
+	if err := database.DB.Select(&entries, models.AuditQueries.Search, actorID, userID, sessionID, limit); err != nil {
+		return nil, fmt.Errorf("failed to get audit log: %v", err)
+	}
+	return entries, nil
+}
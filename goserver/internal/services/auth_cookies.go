@@ -0,0 +1,26 @@
+package services
+
+import "crypto/subtle"
+
+// Cookie mode keeps the tokens out of reach of JavaScript. Clients opt in by
+// sending AuthModeHeader: cookie when they sign in or refresh.
+const (
+	AuthModeHeader     = "X-Auth-Mode"
+	AuthModeCookie     = "cookie"
+	AccessTokenCookie  = "access_token"
+	RefreshTokenCookie = "refresh_token"
+	// CSRFCookie is readable by the frontend, which echoes it in CSRFHeader on
+	// state-changing requests (double-submit).
+	CSRFCookie = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// NewCSRFToken returns a random token for the double-submit CSRF cookie
+func NewCSRFToken() (string, error) {
+	return generateOpaqueToken()
+}
+
+// ValidCSRFToken reports whether the header value matches the CSRF cookie
+func ValidCSRFToken(cookie, header string) bool {
+	return cookie != "" && subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
@@ -1,14 +1,22 @@
 package services
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
+	"goserver/internal/config"
 	"goserver/internal/database"
 	"goserver/internal/models"
-	"os"
+	"log"
+	"net/mail"
+	"regexp"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/google/uuid"
 )
 
 // ValidationError represents a validation error
@@ -22,7 +30,7 @@ func ValidateLoginInput(userName, userPassword string) []ValidationError {
 	var errs []ValidationError
 
 	if userName == "" {
-		errs = append(errs, ValidationError{Field: "user_name", Message: "Username is required"})
+		errs = append(errs, ValidationError{Field: "user_name", Message: "Username or email is required"})
 	}
 
 	if userPassword == "" {
@@ -32,8 +40,120 @@ func ValidateLoginInput(userName, userPassword string) []ValidationError {
 	return errs
 }
 
-// LoginUser handles user authentication and approval check
-func LoginUser(userName, userPassword string) (*models.DbUser, []ValidationError, error) {
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,32}$`)
+
+// ValidateSignupInput validates a self-service signup request
+func ValidateSignupInput(req *models.SignupRequest) []ValidationError {
+	var errs []ValidationError
+
+	if req.Username == "" {
+		errs = append(errs, ValidationError{Field: "user_name", Message: "Username is required"})
+	} else if !usernamePattern.MatchString(req.Username) {
+		errs = append(errs, ValidationError{Field: "user_name", Message: "Username must be 3-32 characters of letters, digits, '.', '_' or '-'"})
+	}
+
+	errs = append(errs, validateEmail(req.Email)...)
+	return append(errs, ValidatePassword(req.Password)...)
+}
+
+// validateEmail checks that an address is a bare, well-formed email
+func validateEmail(email string) []ValidationError {
+	if email == "" {
+		return []ValidationError{{Field: "user_email", Message: "Email is required"}}
+	}
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return []ValidationError{{Field: "user_email", Message: "Email address is not valid"}}
+	}
+	return nil
+}
+
+// ValidatePassword checks a new password against the configured policy
+func ValidatePassword(password string) []ValidationError {
+	var errs []ValidationError
+	policy := config.Load().PasswordPolicy
+
+	if password == "" {
+		return append(errs, ValidationError{Field: "user_password", Message: "Password is required"})
+	}
+
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		errs = append(errs, ValidationError{Field: "user_password", Message: fmt.Sprintf("Password must be at least %d characters", policy.MinLength)})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+
+	if policy.RequireUpper && !hasUpper {
+		errs = append(errs, ValidationError{Field: "user_password", Message: "Password must contain an uppercase letter"})
+	}
+	if policy.RequireLower && !hasLower {
+		errs = append(errs, ValidationError{Field: "user_password", Message: "Password must contain a lowercase letter"})
+	}
+	if policy.RequireDigit && !hasDigit {
+		errs = append(errs, ValidationError{Field: "user_password", Message: "Password must contain a digit"})
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		errs = append(errs, ValidationError{Field: "user_password", Message: "Password must contain a symbol"})
+	}
+	if IsBreachedPassword(password) {
+		errs = append(errs, ValidationError{Field: "user_password", Message: "This password has appeared in a data breach, please choose another"})
+	}
+
+	return errs
+}
+
+// SignupUser validates a signup request and creates an unapproved User account
+func SignupUser(req *models.SignupRequest) (*models.DbUser, []ValidationError, error) {
+	req.Username = strings.TrimSpace(req.Username)
+	req.Email = strings.TrimSpace(req.Email)
+
+	if validationErrors := ValidateSignupInput(req); len(validationErrors) > 0 {
+		return nil, validationErrors, nil
+	}
+
+	var conflicts []ValidationError
+	if _, err := GetUserByUsername(req.Username); err == nil {
+		conflicts = append(conflicts, ValidationError{Field: "user_name", Message: "Username is already taken"})
+	}
+	if _, err := GetUserByEmail(req.Email); err == nil {
+		conflicts = append(conflicts, ValidationError{Field: "user_email", Message: "An account with this email already exists"})
+	}
+	if len(conflicts) > 0 {
+		return nil, conflicts, nil
+	}
+
+	user := &models.DbUser{
+		Username: req.Username,
+		Email:    req.Email,
+		Password: req.Password,
+		Role:     models.USER_ROLES["USER"].Name,
+	}
+	if err := CreateUser(user); err != nil {
+		return nil, nil, err
+	}
+
+	return user, nil, nil
+}
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrEmailNotVerified   = errors.New("please verify your email address before logging in")
+)
+
+// LoginUser handles user authentication and approval check. Every attempt is
+// recorded, and repeated failures lock out the username or client IP.
+func LoginUser(userName, userPassword, ip string) (*models.DbUser, []ValidationError, error) {
 	// Validate input
 	validationErrors := ValidateLoginInput(userName, userPassword)
 
@@ -41,57 +161,116 @@ func LoginUser(userName, userPassword string) (*models.DbUser, []ValidationError
 		return nil, validationErrors, nil
 	}
 
-	// Authenticate user (replace with your DB lookup)
+	// Throttle by account, whether it was named by username or email
+	throttleName := loginThrottleName(userName)
+	wait, err := CheckLoginThrottle(throttleName, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+	if wait > 0 {
+		RecordLoginAttempt(throttleName, ip, false, LoginThrottled)
+		return nil, nil, &LoginThrottledError{RetryAfter: wait}
+	}
+
 	foundUser, err := GetUser(userName, userPassword)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	if foundUser == nil {
-		return nil, nil, errors.New("invalid username or password")
+		RecordLoginAttempt(throttleName, ip, false, LoginInvalidCredentials)
+		return nil, nil, ErrInvalidCredentials
 	}
 
 	// Check if user is approved (email verified)
 	if !foundUser.Approved {
-		return nil, nil, errors.New("please verify your email address before logging in")
+		RecordLoginAttempt(throttleName, ip, false, LoginUnverified)
+		return nil, nil, ErrEmailNotVerified
 	}
 
+	RecordLoginAttempt(throttleName, ip, true, LoginSuccess)
 	return foundUser, nil, nil
 }
 
-// GetUser authenticates user with PostgreSQL database
+// GetUser authenticates user with PostgreSQL database. The identifier may be
+// a username or an email address. It returns nil, nil when the credentials
+// are wrong and an error only for DB failures.
 func GetUser(userName, userPassword string) (*models.DbUser, error) {
 	var user models.DbUser
 
+	// An exact username match wins over an email match
 	query := `
         SELECT id, user_name, user_password, user_email, user_role, user_approved
         FROM users 
-        WHERE user_name = $1
+        WHERE user_name = $1 OR lower(user_email) = lower($1)
+        ORDER BY (user_name = $1) DESC
+        LIMIT 1
     `
 
 	err := database.DB.Get(&user, query, userName)
-	if err != nil {
-		return nil, nil // User not found or database error
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %v", err)
 	}
 
 	// Compare the provided password with the hashed password in the database
-	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(userPassword)) != nil {
+	ok, rehash, err := verifyPassword(user.Password, userPassword)
+	if err != nil {
+		log.Printf("Password check failed for user %d: %v", user.ID, err)
+		return nil, nil
+	}
+	if !ok {
 		return nil, nil // Password does not match
 	}
 
+	// Upgrade bcrypt or outdated argon2id hashes while we have the plain password
+	if rehash {
+		if newHash, err := hashPassword(userPassword); err != nil {
+			log.Printf("Failed to rehash password for user %d: %v", user.ID, err)
+		} else if _, err := database.DB.Exec(models.UserQueries.RehashPassword, newHash, user.ID, user.Password); err != nil {
+			log.Printf("Failed to store rehashed password for user %d: %v", user.ID, err)
+		}
+	}
+
 	return &user, nil
 }
 
-// GenerateAccessToken creates a JWT token for the authenticated user
-func GenerateAccessToken(user *models.DbUser) (string, error) {
+// GenerateAccessToken creates a JWT token for the authenticated user. The
+// session ID ties it to the refresh token family it was issued from.
+func GenerateAccessToken(user *models.DbUser, sessionID string) (string, error) {
+	now := time.Now()
 	claims := jwt.MapClaims{
 		"user_name": user.Username,
 		"user":      user.ID,
 		"role":      user.Role,
-		"exp":       time.Now().Add(time.Hour * 24).Unix(), // Token expires in 24 hours
+		"sid":       sessionID,
+		"jti":       uuid.New().String(),
+		"iat":       float64(now.UnixMilli()) / 1000,              // Sub-second precision so revocation cutoffs are exact
+		"exp":       now.Add(config.Load().AccessTokenTTL).Unix(), // Short-lived; clients renew via refresh token
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	secret := os.Getenv("JWT_SECRET")
-	return token.SignedString([]byte(secret))
+	return signToken(claims)
+}
+
+// ParseAccessToken verifies a JWT and returns its claims. Special-purpose
+// tokens such as MFA challenges are rejected.
+func ParseAccessToken(tokenString string) (jwt.MapClaims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := claims["purpose"]; ok {
+		return nil, errors.New("invalid or expired token")
+	}
+	return claims, nil
+}
+
+// ClaimTime reads a NumericDate claim such as "iat" or "exp"
+func ClaimTime(claims jwt.MapClaims, name string) time.Time {
+	if f, ok := claims[name].(float64); ok {
+		sec := int64(f)
+		return time.Unix(sec, int64((f-float64(sec))*1e9))
+	}
+	return time.Time{}
 }
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"goserver/internal/database"
+	"goserver/internal/models"
+)
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// FieldChange is a single-line field that differs between two revisions
+type FieldChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// RevisionDiff compares two revisions of a post. The body is diffed line by
+// line; the title and category are only reported when they changed.
+type RevisionDiff struct {
+	BlogID   int          `json:"blog_id"`
+	From     int          `json:"from"`
+	To       int          `json:"to"`
+	Title    *FieldChange `json:"blog_subject,omitempty"`
+	Category *FieldChange `json:"blog_category,omitempty"`
+	Added    int          `json:"lines_added"`
+	Removed  int          `json:"lines_removed"`
+	Lines    []DiffLine   `json:"lines"`
+}
+
+// GetBlogRevisions lists a post's revisions, newest first, without their bodies
+func GetBlogRevisions(blogID int) ([]models.DbBlogRevision, error) {
+	revisions := []models.DbBlogRevision{}
+	if err := database.DB.Select(&revisions, models.BlogRevisionQueries.GetByBlogID, blogID); err != nil {
+		return nil, fmt.Errorf("failed to get blog revisions: %v", err)
+	}
+	return revisions, nil
+}
+
+// GetBlogRevision returns one revision of a post with its full content
+func GetBlogRevision(blogID, number int) (*models.DbBlogRevision, error) {
+	var revision models.DbBlogRevision
+	err := database.DB.Get(&revision, models.BlogRevisionQueries.GetByNumber, blogID, number)
+	if err == sql.ErrNoRows {
+		return nil, ErrRevisionNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get blog revision: %v", err)
+	}
+	return &revision, nil
+}
+
+// DiffBlogRevisions compares revision from with revision to. Either may be
+// the older one.
+func DiffBlogRevisions(blogID, from, to int) (*RevisionDiff, error) {
+	oldRevision, err := GetBlogRevision(blogID, from)
+	if err != nil {
+		return nil, err
+	}
+	newRevision, err := GetBlogRevision(blogID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &RevisionDiff{
+		BlogID: blogID,
+		From:   from,
+		To:     to,
+		Lines:  diffLines(splitLines(oldRevision.Content), splitLines(newRevision.Content)),
+	}
+	if oldRevision.Title != newRevision.Title {
+		diff.Title = &FieldChange{From: oldRevision.Title, To: newRevision.Title}
+	}
+	if oldRevision.Category != newRevision.Category {
+		diff.Category = &FieldChange{From: oldRevision.Category, To: newRevision.Category}
+	}
+	for _, line := range diff.Lines {
+		switch line.Op {
+		case DiffInsert:
+			diff.Added++
+		case DiffDelete:
+			diff.Removed++
+		}
+	}
+	return diff, nil
+}
+
+// RestoreBlogRevision saves an older revision's content over blog as a new
+// revision, so the history keeps everything in between. blog must be the
+// version the caller loaded; ErrBlogModified is returned if it has changed.
+func RestoreBlogRevision(blog *models.DbBlog, number int, editor *models.DbUser) (*models.DbBlog, int, error) {
+	revision, err := GetBlogRevision(blog.ID, number)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	restored := *blog
+	restored.Title = revision.Title
+	restored.Content = revision.Content
+	restored.Category = revision.Category
+
+	newNumber, err := saveBlog(&restored, editor, &revision.Number)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	log.Printf("User %d restored blog %d to revision %d as revision %d", editor.ID, blog.ID, number, newNumber)
+	return &restored, newNumber, nil
+}
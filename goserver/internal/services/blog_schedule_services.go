@@ -0,0 +1,77 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"goserver/internal/config"
+	"goserver/internal/database"
+	"goserver/internal/models"
+)
+
+// StartBlogScheduler publishes scheduled posts as their publish_at passes,
+// checking every tick. It runs for the life of the process.
+func StartBlogScheduler(tick time.Duration) {
+	if tick <= 0 {
+		tick = time.Minute
+	}
+	go func() {
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for {
+			if _, err := PublishDueBlogs(); err != nil {
+				log.Printf("Blog scheduler: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+	log.Printf("Blog scheduler started, checking every %s", tick)
+}
+
+// PublishDueBlogs publishes every scheduled post whose publish_at has passed,
+// then announces every published post that has not been announced yet, so a
+// post missed by an earlier run is picked up. Safe to run from several
+// servers at once.
+func PublishDueBlogs() (int, error) {
+	var ids []int
+	if err := database.DB.Select(&ids, models.BlogQueries.PublishDue); err != nil {
+		return 0, fmt.Errorf("failed to publish scheduled blogs: %v", err)
+	}
+	for _, id := range ids {
+		log.Printf("Published scheduled blog %d", id)
+	}
+
+	var unannounced []int
+	if err := database.DB.Select(&unannounced, models.BlogQueries.Unannounced); err != nil {
+		return len(ids), fmt.Errorf("failed to find unannounced blogs: %v", err)
+	}
+	for _, id := range unannounced {
+		notifyBlogPublished(id)
+	}
+	return len(ids), nil
+}
+
+// notifyBlogPublished sends SendBlogNotification for a published post unless
+// it has been sent before. Claiming notified_at first means a post that is
+// unpublished and published again, or published by two servers at once, is
+// only announced once.
+func notifyBlogPublished(blogID int) {
+	var blog models.DbBlog
+	err := database.DB.Get(&blog, models.BlogQueries.MarkNotified, blogID)
+	if err == sql.ErrNoRows {
+		return
+	} else if err != nil {
+		log.Printf("Failed to mark blog %d as announced: %v", blogID, err)
+		return
+	}
+
+	recipients := config.Load().BlogNotifyEmails
+	if len(recipients) == 0 {
+		recipients = []string{blog.Email}
+	}
+	for _, recipient := range recipients {
+		SendBlogNotification(recipient, blog.Title, blog.AuthorID)
+	}
+}
@@ -1,17 +1,22 @@
 package services
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
+	"time"
 
 	"goserver/internal/database"
 	"goserver/internal/models"
 )
 
-func GetAllBlogs() ([]models.DbBlog, error) {
+// GetAllBlogs lists published posts, plus any other posts owned by viewer
+// (a username, or empty for anonymous callers)
+func GetAllBlogs(viewer string) ([]models.DbBlog, error) {
 	var blogs []models.DbBlog
-	err := database.DB.Select(&blogs, models.BlogQueries.GetAll)
+	err := database.DB.Select(&blogs, models.BlogQueries.GetAll, viewer)
 	if err != nil {
 		return nil, err
 	}
@@ -33,42 +38,171 @@ func GetBlogByID(id string) (*models.DbBlog, error) {
 	return &blog, nil
 }
 
-// SaveBlog creates a new blog or updates an existing one based on blog_id
-func SaveBlog(data *models.DbBlog) (string, error) {
+var (
+	// ErrBlogModified means the post changed after the caller loaded it
+	ErrBlogModified      = errors.New("blog post has been changed since you loaded it")
+	ErrInvalidBlogStatus = errors.New("status must be draft, scheduled, published or archived")
+	ErrPublishAtRequired = errors.New("publish_at is required to schedule a post")
+)
+
+// BlogETag identifies the version of a post, derived from updated_at
+func BlogETag(blog *models.DbBlog) string {
+	return fmt.Sprintf(`"%d"`, blog.UpdatedAt.UnixMicro())
+}
+
+// BlogUpdateRequest holds the editable fields of a post. PUT must send the
+// title, body and category; PATCH sends only the fields that change.
+type BlogUpdateRequest struct {
+	Title     *string    `json:"blog_subject"`
+	Content   *string    `json:"blog_body"`
+	Category  *string    `json:"blog_category"`
+	Status    *string    `json:"status"`
+	PublishAt *time.Time `json:"publish_at"`
+}
+
+// Apply returns a copy of blog with the requested changes
+func (r *BlogUpdateRequest) Apply(blog *models.DbBlog) *models.DbBlog {
+	updated := *blog
+	if r.Title != nil {
+		updated.Title = *r.Title
+	}
+	if r.Content != nil {
+		updated.Content = *r.Content
+	}
+	if r.Category != nil {
+		updated.Category = *r.Category
+	}
+	if r.Status != nil {
+		updated.Status = *r.Status
+	}
+	if r.PublishAt != nil {
+		updated.PublishAt = r.PublishAt
+	}
+	return &updated
+}
+
+// SaveBlog creates a new blog or updates an existing one based on blog_id,
+// recording the result as a revision by editor. An update only succeeds if
+// the stored post is still at data.UpdatedAt; otherwise ErrBlogModified is
+// returned.
+func SaveBlog(data *models.DbBlog, editor *models.DbUser) (string, error) {
+	_, err := saveBlog(data, editor, nil)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(data.ID), nil
+}
+
+// saveBlog writes the post and its revision in one transaction and returns
+// the revision number
+func saveBlog(data *models.DbBlog, editor *models.DbUser, restoredFrom *int) (int, error) {
+	if err := normalizeBlogStatus(data); err != nil {
+		return 0, err
+	}
+
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
 	if data.ID != 0 {
 		// Update existing blog
-		var updatedBlog models.DbBlog
-		_, err := database.DB.Exec(models.BlogQueries.Update,
+		if _, err := tx.Exec(models.BlogRevisionQueries.LockBlog, data.ID); err != nil {
+			return 0, fmt.Errorf("failed to lock blog: %v", err)
+		}
+		// Posts written before revisions were kept get their current
+		// content as revision 1, so the first edit can be undone
+		if _, err := tx.Exec(models.BlogRevisionQueries.InsertBaseline, data.ID); err != nil {
+			return 0, fmt.Errorf("failed to record blog revision: %v", err)
+		}
+
+		err := tx.QueryRowx(models.BlogQueries.Update,
 			data.Title,
 			data.Content,
 			data.AuthorID,
 			data.Email,
 			data.Category,
+			data.Status,
+			data.PublishAt,
 			data.ID,
-		)
+			data.UpdatedAt.UnixMicro(),
+		).Scan(&data.CreatedAt, &data.UpdatedAt)
 
-		if err != nil {
-			return "", err
+		if err == sql.ErrNoRows {
+			return 0, ErrBlogModified
+		} else if err != nil {
+			return 0, err
 		}
-		log.Printf("Saved Blog: %s", updatedBlog.Title)
-		return strconv.Itoa(data.ID), nil
 	} else {
 		// Create new blog
 		log.Printf("Creating new blog post.")
-		err := database.DB.QueryRowx(models.BlogQueries.Insert,
+		err := tx.QueryRowx(models.BlogQueries.Insert,
 			data.Title,
 			data.Content,
 			data.AuthorID,
 			data.Email,
 			data.Category,
-		).Scan(&data.ID)
+			data.Status,
+			data.PublishAt,
+		).Scan(&data.ID, &data.CreatedAt, &data.UpdatedAt)
 
 		if err != nil {
-			return "", err
+			return 0, err
+		}
+	}
+
+	var revision int
+	err = tx.Get(&revision, models.BlogRevisionQueries.Insert,
+		data.ID,
+		data.Title,
+		data.Content,
+		data.Category,
+		editor.ID,
+		editor.Username,
+		restoredFrom,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record blog revision: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit blog: %v", err)
+	}
+	log.Printf("Saved Blog: %s (revision %d)", data.Title, revision)
+	if data.Status == models.BlogPublished {
+		notifyBlogPublished(data.ID)
+	}
+	return revision, nil
+}
+
+// normalizeBlogStatus checks the requested status and fills in publish_at.
+// New posts are published unless told otherwise, as before posts had a
+// status, a schedule in the past publishes straight away, and a published
+// post records when it went live.
+func normalizeBlogStatus(data *models.DbBlog) error {
+	if data.Status == "" {
+		data.Status = models.BlogPublished
+	}
+
+	now := time.Now()
+	switch data.Status {
+	case models.BlogDraft, models.BlogArchived:
+	case models.BlogScheduled:
+		if data.PublishAt == nil {
+			return ErrPublishAtRequired
+		}
+		if !data.PublishAt.After(now) {
+			data.Status = models.BlogPublished
 		}
-		log.Printf("Saved Blog: %s", data.Title)
-		return strconv.Itoa(data.ID), nil
+	case models.BlogPublished:
+		if data.PublishAt == nil || data.PublishAt.After(now) {
+			data.PublishAt = &now
+		}
+	default:
+		return ErrInvalidBlogStatus
 	}
+	return nil
 }
 
 // DeleteBlog deletes a blog by its ID
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// ceremonyStore keeps short-lived, single-use state for multi-step sign-in
+// ceremonies (WebAuthn challenges, OAuth state) between two requests.
+type ceremonyStore[T any] struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	items map[string]ceremonyItem[T]
+}
+
+type ceremonyItem[T any] struct {
+	value   T
+	expires time.Time
+}
+
+func newCeremonyStore[T any](ttl time.Duration) *ceremonyStore[T] {
+	return &ceremonyStore[T]{ttl: ttl, items: map[string]ceremonyItem[T]{}}
+}
+
+// Put stores value under a new random key and returns the key
+func (s *ceremonyStore[T]) Put(value T) (string, error) {
+	key, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, item := range s.items {
+		if now.After(item.expires) {
+			delete(s.items, k)
+		}
+	}
+	s.items[key] = ceremonyItem[T]{value: value, expires: now.Add(s.ttl)}
+	return key, nil
+}
+
+// Take removes and returns the value for key if it exists and hasn't expired
+func (s *ceremonyStore[T]) Take(key string) (T, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[key]
+	delete(s.items, key)
+	if !ok || time.Now().After(item.expires) {
+		var zero T
+		return zero, false
+	}
+	return item.value, true
+}
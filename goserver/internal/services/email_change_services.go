@@ -0,0 +1,175 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"goserver/internal/database"
+	"goserver/internal/models"
+)
+
+const emailChangeTTL = 24 * time.Hour
+
+var (
+	ErrInvalidEmailChange = errors.New("invalid, expired or cancelled email change")
+	ErrNoEmailChange      = errors.New("no email change is pending")
+	ErrEmailUnavailable   = errors.New("email address is not valid or is already in use")
+)
+
+// StartEmailChange records a pending change to newEmail. The new address is
+// sent a confirmation code and the current one a cancel link; the account
+// keeps its current address until the code is confirmed.
+func StartEmailChange(user *models.DbUser, newEmail string) ([]ValidationError, error) {
+	newEmail = strings.TrimSpace(newEmail)
+	if validationErrors := validateEmail(newEmail); len(validationErrors) > 0 {
+		return validationErrors, nil
+	}
+	if strings.EqualFold(newEmail, user.Email) {
+		return []ValidationError{{Field: "user_email", Message: "This is already your email address"}}, nil
+	}
+	if existing, err := GetUserByEmail(newEmail); err == nil && existing.ID != user.ID {
+		return []ValidationError{{Field: "user_email", Message: "An account with this email already exists"}}, nil
+	}
+
+	code, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	cancelToken, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Only the most recent request can be confirmed
+	if _, err := tx.Exec(models.EmailChangeQueries.CancelForUser, user.ID); err != nil {
+		return nil, fmt.Errorf("failed to cancel pending email changes: %v", err)
+	}
+
+	expiresAt := time.Now().Add(emailChangeTTL)
+	if _, err := tx.Exec(models.EmailChangeQueries.Insert, user.ID, newEmail, hashToken(code), hashToken(cancelToken), expiresAt); err != nil {
+		return nil, fmt.Errorf("failed to store email change: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit email change: %v", err)
+	}
+
+	log.Printf("User %d started an email change", user.ID)
+	SendEmailChangeNoticeEmail(user.Email, user.Username, newEmail, cancelToken)
+	return nil, SendEmailChangeVerificationEmail(newEmail, user.Username, code)
+}
+
+// GetPendingEmailChange returns the user's unconfirmed email change, or nil
+func GetPendingEmailChange(userID int) (*models.DbEmailChange, error) {
+	var change models.DbEmailChange
+	err := database.DB.Get(&change, models.EmailChangeQueries.GetPendingByUser, userID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get email change: %v", err)
+	}
+	return &change, nil
+}
+
+// ConfirmEmailChange consumes the code sent to the new address and swaps it in
+func ConfirmEmailChange(code string) (*models.DbUser, error) {
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var change models.DbEmailChange
+	err = tx.Get(&change, models.EmailChangeQueries.GetByCodeHashForUpdate, hashToken(code))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidEmailChange
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to look up email change: %v", err)
+	}
+
+	if change.ConfirmedAt != nil || change.CancelledAt != nil || time.Now().After(change.ExpiresAt) {
+		return nil, ErrInvalidEmailChange
+	}
+
+	// The address may have been taken since the change was started
+	if existing, err := GetUserByEmail(change.NewEmail); err == nil && existing.ID != change.UserID {
+		return nil, ErrEmailUnavailable
+	}
+
+	user, err := GetUserByID(change.UserID)
+	if err != nil {
+		return nil, ErrInvalidEmailChange
+	}
+	oldEmail := user.Email
+
+	if _, err := tx.Exec(models.UserQueries.UpdateEmail, change.NewEmail, change.UserID); err != nil {
+		return nil, fmt.Errorf("failed to update email: %v", err)
+	}
+	if _, err := tx.Exec(models.EmailChangeQueries.MarkConfirmed, change.ID); err != nil {
+		return nil, fmt.Errorf("failed to consume email change: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit email change: %v", err)
+	}
+
+	log.Printf("User %d confirmed an email change", user.ID)
+	user.Email = change.NewEmail
+	SendEmailChangedEmail(oldEmail, user.Username)
+	return user, nil
+}
+
+// CancelEmailChange cancels a pending change using the token emailed to the
+// old address
+func CancelEmailChange(cancelToken string) error {
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var change models.DbEmailChange
+	err = tx.Get(&change, models.EmailChangeQueries.GetByCancelHashForUpdate, hashToken(cancelToken))
+	if err == sql.ErrNoRows {
+		return ErrInvalidEmailChange
+	} else if err != nil {
+		return fmt.Errorf("failed to look up email change: %v", err)
+	}
+
+	if change.ConfirmedAt != nil || change.CancelledAt != nil || time.Now().After(change.ExpiresAt) {
+		return ErrInvalidEmailChange
+	}
+
+	if _, err := tx.Exec(models.EmailChangeQueries.MarkCancelled, change.ID); err != nil {
+		return fmt.Errorf("failed to cancel email change: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit email change: %v", err)
+	}
+
+	log.Printf("Email change %d for user %d cancelled from the old address", change.ID, change.UserID)
+	return nil
+}
+
+// CancelUserEmailChange cancels the signed-in user's pending change
+func CancelUserEmailChange(userID int) error {
+	var cancelled []int
+	if err := database.DB.Select(&cancelled, models.EmailChangeQueries.CancelForUser, userID); err != nil {
+		return fmt.Errorf("failed to cancel email change: %v", err)
+	}
+	if len(cancelled) == 0 {
+		return ErrNoEmailChange
+	}
+	return nil
+}
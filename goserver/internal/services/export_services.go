@@ -0,0 +1,146 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"goserver/internal/database"
+	"goserver/internal/models"
+)
+
+// exportFormatVersion changes whenever a file in the export changes shape
+const exportFormatVersion = 1
+
+// exportFile is one JSON document in a personal data export
+type exportFile struct {
+	Name        string
+	Description string
+	Records     int
+	Data        any
+}
+
+// UserExport is everything stored about one user, gathered up front so a
+// database error is reported before any of the archive is sent.
+type UserExport struct {
+	UserID      int
+	GeneratedAt time.Time
+	files       []exportFile
+}
+
+// exportManifest describes the archive so it can be read by a program
+type exportManifest struct {
+	FormatVersion int                   `json:"format_version"`
+	GeneratedAt   time.Time             `json:"generated_at"`
+	UserID        int                   `json:"user_id"`
+	Files         []exportManifestEntry `json:"files"`
+	NotCollected  []string              `json:"not_collected"`
+}
+
+type exportManifestEntry struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Records     int    `json:"records"`
+}
+
+// BuildUserExport collects the user's personal data. Secrets such as
+// password hashes, MFA secrets and token hashes are never included.
+func BuildUserExport(user *models.DbUser) (*UserExport, error) {
+	export := &UserExport{UserID: user.ID, GeneratedAt: time.Now().UTC()}
+	export.add("profile.json", "Your account record", 1, user)
+
+	comments := []models.DbComment{}
+	if err := database.DB.Select(&comments, models.CommentQueries.GetByAuthor, user.Email); err != nil {
+		return nil, fmt.Errorf("failed to get comments: %v", err)
+	}
+	export.add("comments.json", "Comments posted with your email address", len(comments), comments)
+
+	blogs := []models.DbBlog{}
+	if err := database.DB.Select(&blogs, models.BlogQueries.GetByOwner, user.Username, user.Email); err != nil {
+		return nil, fmt.Errorf("failed to get blog posts: %v", err)
+	}
+	export.add("blog_posts.json", "Blog posts you own", len(blogs), blogs)
+
+	revisions := []models.DbBlogRevision{}
+	if err := database.DB.Select(&revisions, models.BlogRevisionQueries.GetByAuthor, user.ID); err != nil {
+		return nil, fmt.Errorf("failed to get blog revisions: %v", err)
+	}
+	export.add("blog_revisions.json", "Every version of a blog post you saved", len(revisions), revisions)
+
+	roleRequests, err := GetUserRoleRequests(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	export.add("role_requests.json", "Requests you made for a different role", len(roleRequests), roleRequests)
+
+	sessions, err := GetSessions(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	export.add("sessions.json", "Devices that are currently signed in", len(sessions), sessions)
+
+	loginAttempts, err := GetLoginAttempts(user.Username, "", false, 1000)
+	if err != nil {
+		return nil, err
+	}
+	export.add("login_attempts.json", "Your most recent 1000 sign-in attempts, with IP addresses", len(loginAttempts), loginAttempts)
+
+	identities := []models.DbUserIdentity{}
+	if err := database.DB.Select(&identities, models.IdentityQueries.GetByUserID, user.ID); err != nil {
+		return nil, fmt.Errorf("failed to get linked accounts: %v", err)
+	}
+	export.add("linked_accounts.json", "External sign-in providers linked to your account", len(identities), identities)
+
+	passkeys, err := GetPasskeys(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	export.add("passkeys.json", "Registered passkeys, without key material", len(passkeys), passkeys)
+
+	tokens, err := GetPersonalTokens(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	export.add("personal_access_tokens.json", "Personal access tokens, without the token values", len(tokens), tokens)
+
+	mfaEnabled, err := MFAEnabled(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	export.add("two_factor.json", "Whether two-factor authentication is enabled", 1, map[string]bool{"enabled": mfaEnabled})
+
+	return export, nil
+}
+
+func (e *UserExport) add(name, description string, records int, data any) {
+	e.files = append(e.files, exportFile{Name: name, Description: description, Records: records, Data: data})
+}
+
+// WriteZip streams the export as a zip archive with manifest.json first
+func (e *UserExport) WriteZip(w io.Writer) error {
+	manifest := exportManifest{
+		FormatVersion: exportFormatVersion,
+		GeneratedAt:   e.GeneratedAt,
+		UserID:        e.UserID,
+		Files:         make([]exportManifestEntry, 0, len(e.files)),
+		NotCollected:  []string{"Download history: file downloads are not recorded per user"},
+	}
+	for _, file := range e.files {
+		manifest.Files = append(manifest.Files, exportManifestEntry{Name: file.Name, Description: file.Description, Records: file.Records})
+	}
+
+	entries := []ZipEntry{{Name: "manifest.json", Modified: e.GeneratedAt, Write: jsonWriter(manifest)}}
+	for _, file := range e.files {
+		entries = append(entries, ZipEntry{Name: file.Name, Modified: e.GeneratedAt, Write: jsonWriter(file.Data)})
+	}
+	return StreamZip(w, entries)
+}
+
+func jsonWriter(v any) func(io.Writer) error {
+	return func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 const BASE_PATH = "DiscoveryDrawings" // Adjust this path as needed
@@ -392,3 +393,32 @@ func CreateZipFromFiles(yearMake, model string, fileNames []string) (string, err
 
 	return zipPath, nil
 }
+
+// ZipEntry is one file written into a streamed archive
+type ZipEntry struct {
+	Name     string
+	Modified time.Time
+	Write    func(w io.Writer) error
+}
+
+// StreamZip writes the entries as a zip archive straight to w, so nothing is
+// staged in temp files
+func StreamZip(w io.Writer, entries []ZipEntry) error {
+	zipWriter := zip.NewWriter(w)
+
+	for _, entry := range entries {
+		zipEntry, err := zipWriter.CreateHeader(&zip.FileHeader{
+			Name:     entry.Name,
+			Method:   zip.Deflate,
+			Modified: entry.Modified,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add %s to archive: %v", entry.Name, err)
+		}
+		if err := entry.Write(zipEntry); err != nil {
+			return fmt.Errorf("failed to write %s to archive: %v", entry.Name, err)
+		}
+	}
+
+	return zipWriter.Close()
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"goserver/internal/config"
+	"goserver/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrImpersonateSelf        = errors.New("you cannot impersonate yourself")
+	ErrImpersonationForbidden = errors.New("you can only impersonate users whose role is below yours")
+	ErrAlreadyImpersonating   = errors.New("end the current impersonation before starting another")
+	ErrImpersonationReason    = errors.New("a reason is required to impersonate a user")
+)
+
+// Impersonation is returned to an admin who starts impersonating a user
+type Impersonation struct {
+	AccessToken string            `json:"accessToken"`
+	ExpiresIn   int64             `json:"expiresIn"`
+	SessionID   string            `json:"sessionId"`
+	User        models.PublicUser `json:"user"`
+}
+
+// StartImpersonation issues a short-lived, read-only access token that acts
+// as the target user. Its "act" claim names the admin, and there is no
+// refresh token: when it expires the admin is back to their own session.
+func StartImpersonation(admin *models.DbUser, targetID int, reason, ip string) (*Impersonation, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrImpersonationReason
+	}
+	if admin.ID == targetID {
+		return nil, ErrImpersonateSelf
+	}
+
+	target, err := GetUserByID(targetID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	// Seeing the site as someone with more access would be an escalation
+	adminRole, _ := GetRole(admin.Role)
+	targetRole, _ := GetRole(target.Role)
+	if targetRole.Level >= adminRole.Level {
+		return nil, ErrImpersonationForbidden
+	}
+
+	ttl := config.Load().ImpersonationTTL
+	sessionID := uuid.New().String()
+	now := time.Now()
+	token, err := signToken(jwt.MapClaims{
+		"user_name": target.Username,
+		"user":      target.ID,
+		"role":      target.Role,
+		"sid":       sessionID,
+		"jti":       uuid.New().String(),
+		"act":       map[string]any{"sub": admin.ID, "user_name": admin.Username},
+		"iat":       float64(now.UnixMilli()) / 1000,
+		"exp":       now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("User %d started impersonating user %d", admin.ID, target.ID)
+	RecordAudit(&models.DbAuditEntry{
+		ActorID:   &admin.ID,
+		UserID:    &target.ID,
+		Action:    models.AuditImpersonationStart,
+		IPAddress: ip,
+		SessionID: sessionID,
+		Details:   reason,
+	})
+
+	return &Impersonation{
+		AccessToken: token,
+		ExpiresIn:   int64(ttl.Seconds()),
+		SessionID:   sessionID,
+		User:        target.Public(),
+	}, nil
+}
+
+// EndImpersonation revokes an impersonation token before it expires
+func EndImpersonation(actorID, userID int, jti, sessionID, ip string, expiresAt time.Time) error {
+	if err := RevokeToken(jti, userID, expiresAt); err != nil {
+		return err
+	}
+	RecordAudit(&models.DbAuditEntry{
+		ActorID:   &actorID,
+		UserID:    &userID,
+		Action:    models.AuditImpersonationEnd,
+		IPAddress: ip,
+		SessionID: sessionID,
+	})
+	return nil
+}
+
+// ImpersonatorID returns the real admin behind an impersonation token
+func ImpersonatorID(claims jwt.MapClaims) (int, bool) {
+	act, ok := claims["act"].(map[string]any)
+	if !ok {
+		return 0, false
+	}
+	sub, ok := act["sub"].(float64)
+	return int(sub), ok
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"goserver/internal/database"
+	"goserver/internal/models"
+)
+
+const (
+	defaultInvitationDays = 7
+	maxInvitationDays     = 30
+)
+
+var (
+	ErrInvalidInvitation  = errors.New("invalid, expired or revoked invitation")
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrInvitationClosed   = errors.New("invitation has already been accepted or revoked")
+)
+
+var roleAboveInviterError = ValidationError{Field: "role", Message: "You can't invite someone to a role with more access than your own"}
+
+// CreateInvitationRequest is the body accepted when inviting someone
+type CreateInvitationRequest struct {
+	Email         string `json:"email"`
+	Role          string `json:"role"`
+	ExpiresInDays int    `json:"expires_in_days"`
+}
+
+// AcceptInvitationRequest is the account an invitee chooses when accepting
+type AcceptInvitationRequest struct {
+	Token    string `json:"token"`
+	Username string `json:"user_name"`
+	Password string `json:"user_password"`
+	DeviceID string `json:"device_id"`
+}
+
+func invitationExpiry(days int) (time.Time, []ValidationError) {
+	if days == 0 {
+		days = defaultInvitationDays
+	}
+	if days < 1 || days > maxInvitationDays {
+		return time.Time{}, []ValidationError{{Field: "expires_in_days", Message: fmt.Sprintf("Expiry must be between 1 and %d days", maxInvitationDays)}}
+	}
+	return time.Now().AddDate(0, 0, days), nil
+}
+
+// CreateInvitation invites an email address to join with a role and sends
+// the single-use link. The role may not carry more access than the inviter's.
+func CreateInvitation(inviter *models.DbUser, req *CreateInvitationRequest) (*models.DbInvitation, []ValidationError, error) {
+	req.Email = strings.TrimSpace(req.Email)
+	validationErrors := validateEmail(req.Email)
+	for i := range validationErrors {
+		validationErrors[i].Field = "email"
+	}
+	if _, ok := GetRole(req.Role); !ok {
+		validationErrors = append(validationErrors, ValidationError{Field: "role", Message: "Unknown role"})
+	} else if !canGrantRole(inviter, req.Role) {
+		validationErrors = append(validationErrors, roleAboveInviterError)
+	}
+	expiresAt, expiryErrors := invitationExpiry(req.ExpiresInDays)
+	validationErrors = append(validationErrors, expiryErrors...)
+	if len(validationErrors) > 0 {
+		return nil, validationErrors, nil
+	}
+
+	if _, err := GetUserByEmail(req.Email); err == nil {
+		return nil, []ValidationError{{Field: "email", Message: "An account with this email already exists"}}, nil
+	}
+	var pending []int
+	if err := database.DB.Select(&pending, models.InvitationQueries.GetPendingByEmail, req.Email); err != nil {
+		return nil, nil, fmt.Errorf("failed to check invitations: %v", err)
+	}
+	if len(pending) > 0 {
+		return nil, []ValidationError{{Field: "email", Message: "This address already has a pending invitation; resend it instead"}}, nil
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var id int
+	err = database.DB.Get(&id, models.InvitationQueries.Insert, req.Email, req.Role, hashToken(token), inviter.ID, expiresAt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create invitation: %v", err)
+	}
+
+	invitation, err := GetInvitation(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Printf("User %d invited a new %s (invitation %d)", inviter.ID, req.Role, id)
+	SendInvitationEmail(invitation.Email, inviter.Username, invitation.Role, token, invitation.ExpiresAt)
+	return invitation, nil, nil
+}
+
+// GetInvitations lists every invitation, newest first
+func GetInvitations() ([]models.DbInvitation, error) {
+	invitations := []models.DbInvitation{}
+	if err := database.DB.Select(&invitations, models.InvitationQueries.GetAll); err != nil {
+		return nil, fmt.Errorf("failed to get invitations: %v", err)
+	}
+	return invitations, nil
+}
+
+// GetInvitation looks up an invitation by ID
+func GetInvitation(id int) (*models.DbInvitation, error) {
+	var invitation models.DbInvitation
+	err := database.DB.Get(&invitation, models.InvitationQueries.GetByID, id)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvitationNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get invitation: %v", err)
+	}
+	return &invitation, nil
+}
+
+// LookupInvitation returns the pending invitation a link's token belongs to,
+// so the accept page can show who it is for
+func LookupInvitation(token string) (*models.DbInvitation, error) {
+	var invitation models.DbInvitation
+	err := database.DB.Get(&invitation, models.InvitationQueries.GetByHash, hashToken(token))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidInvitation
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to look up invitation: %v", err)
+	}
+	if invitation.Status != models.InvitationPending {
+		return nil, ErrInvalidInvitation
+	}
+	return &invitation, nil
+}
+
+// RevokeInvitation stops an unaccepted invitation's link from working
+func RevokeInvitation(id int) error {
+	result, err := database.DB.Exec(models.InvitationQueries.Revoke, id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke invitation: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		if _, err := GetInvitation(id); err != nil {
+			return err
+		}
+		return ErrInvitationClosed
+	}
+	return nil
+}
+
+// ResendInvitation emails a fresh link with a new expiry. The previous link
+// stops working. Like creating one, the sender must be able to grant the role.
+func ResendInvitation(id int, sender *models.DbUser, expiresInDays int) (*models.DbInvitation, []ValidationError, error) {
+	expiresAt, validationErrors := invitationExpiry(expiresInDays)
+	if len(validationErrors) > 0 {
+		return nil, validationErrors, nil
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The invitation may be from someone with more access than the sender
+	invitation, err := GetInvitation(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !canGrantRole(sender, invitation.Role) {
+		return nil, []ValidationError{roleAboveInviterError}, nil
+	}
+
+	result, err := database.DB.Exec(models.InvitationQueries.Renew, hashToken(token), expiresAt, id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to renew invitation: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil, nil, ErrInvitationClosed
+	}
+	invitation.ExpiresAt = expiresAt
+
+	log.Printf("User %d resent invitation %d", sender.ID, id)
+	SendInvitationEmail(invitation.Email, sender.Username, invitation.Role, token, invitation.ExpiresAt)
+	return invitation, nil, nil
+}
+
+// AcceptInvitation creates the invitee's account with the invited role. The
+// address is already proven by the emailed link, so the account starts out
+// verified.
+func AcceptInvitation(req *AcceptInvitationRequest) (*models.DbUser, []ValidationError, error) {
+	var validationErrors []ValidationError
+	req.Username = strings.TrimSpace(req.Username)
+	if !usernamePattern.MatchString(req.Username) {
+		validationErrors = append(validationErrors, ValidationError{Field: "user_name", Message: "Username must be 3-32 characters of letters, digits, '.', '_' or '-'"})
+	}
+	validationErrors = append(validationErrors, ValidatePassword(req.Password)...)
+	if len(validationErrors) > 0 {
+		return nil, validationErrors, nil
+	}
+
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var invitation models.DbInvitation
+	err = tx.Get(&invitation, models.InvitationQueries.GetByHashForUpdate, hashToken(req.Token))
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrInvalidInvitation
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("failed to look up invitation: %v", err)
+	}
+	if invitation.Status != models.InvitationPending {
+		return nil, nil, ErrInvalidInvitation
+	}
+	// The role may have been deleted since the invitation was sent
+	if _, ok := GetRole(invitation.Role); !ok {
+		return nil, nil, ErrInvalidInvitation
+	}
+
+	if _, err := GetUserByUsername(req.Username); err == nil {
+		return nil, []ValidationError{{Field: "user_name", Message: "This username is already taken"}}, nil
+	}
+	if _, err := GetUserByEmail(invitation.Email); err == nil {
+		return nil, nil, ErrInvalidInvitation
+	}
+
+	passwordHash, err := hashPassword(req.Password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user := &models.DbUser{
+		Username: req.Username,
+		Email:    invitation.Email,
+		Role:     invitation.Role,
+		Approved: true,
+	}
+	err = tx.QueryRowx(models.UserQueries.InsertApproved, user.Username, passwordHash, user.Email, user.Role).
+		Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create user: %v", err)
+	}
+
+	if _, err := tx.Exec(models.InvitationQueries.MarkAccepted, user.ID, invitation.ID); err != nil {
+		return nil, nil, fmt.Errorf("failed to accept invitation: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit invitation: %v", err)
+	}
+
+	log.Printf("Invitation %d accepted by new user %d", invitation.ID, user.ID)
+	SendWelcomeEmail(user.Email, user.Username)
+	return user, nil, nil
+}
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+
+	"goserver/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtKey is one asymmetric key. The key ID is its RFC 7638 thumbprint so it
+// stays stable across restarts without extra configuration.
+type jwtKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	public  crypto.PublicKey
+	private crypto.Signer // nil for verification-only keys
+}
+
+// jwtKeySet holds the active signing key and every key still accepted for
+// verification. Without a signing key file the server falls back to HS256.
+type jwtKeySet struct {
+	signing    *jwtKey
+	verify     map[string]*jwtKey
+	hmacSecret []byte
+}
+
+var (
+	jwtKeysOnce sync.Once
+	jwtKeys     *jwtKeySet
+	jwtKeysErr  error
+)
+
+// LoadSigningKeys reads the configured JWT keys. Call at startup so a bad
+// key file stops the server instead of failing the first login.
+func LoadSigningKeys() error {
+	_, err := getJWTKeys()
+	return err
+}
+
+func getJWTKeys() (*jwtKeySet, error) {
+	jwtKeysOnce.Do(func() {
+		jwtKeys, jwtKeysErr = loadJWTKeys(config.Load())
+		if jwtKeysErr == nil {
+			if jwtKeys.signing != nil {
+				log.Printf("Signing JWTs with %s key %s (%d verification keys)", jwtKeys.signing.method.Alg(), jwtKeys.signing.kid, len(jwtKeys.verify))
+			} else {
+				log.Printf("Signing JWTs with HS256 shared secret")
+			}
+		}
+	})
+	return jwtKeys, jwtKeysErr
+}
+
+func loadJWTKeys(cfg *config.Config) (*jwtKeySet, error) {
+	keys := &jwtKeySet{verify: map[string]*jwtKey{}}
+
+	if cfg.JWTSigningKeyFile == "" {
+		keys.hmacSecret = []byte(cfg.JWTSecret)
+		return keys, nil
+	}
+
+	signing, err := readJWTKeyFile(cfg.JWTSigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if signing.private == nil {
+		return nil, fmt.Errorf("%s does not contain a private key", cfg.JWTSigningKeyFile)
+	}
+	keys.signing = signing
+	keys.verify[signing.kid] = signing
+
+	for _, path := range cfg.JWTVerifyKeyFiles {
+		key, err := readJWTKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		keys.verify[key.kid] = key
+	}
+
+	return keys, nil
+}
+
+// readJWTKeyFile parses a PEM file holding an Ed25519 or RSA key, either
+// private (PKCS#8 or PKCS#1) or public (PKIX).
+func readJWTKeyFile(path string) (*jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT key %s: %v", path, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM in %s", path)
+	}
+
+	var parsed any
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT key %s: %v", path, err)
+	}
+
+	key := &jwtKey{}
+	switch k := parsed.(type) {
+	case ed25519.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodEdDSA, k, k.Public()
+	case *rsa.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodRS256, k, k.Public()
+	case ed25519.PublicKey:
+		key.method, key.public = jwt.SigningMethodEdDSA, k
+	case *rsa.PublicKey:
+		key.method, key.public = jwt.SigningMethodRS256, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T in %s (use Ed25519 or RSA)", parsed, path)
+	}
+
+	if key.kid, err = jwkThumbprint(key.public); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// jwkFields returns the required public members of a key's JWK
+func jwkFields(public crypto.PublicKey) (map[string]string, error) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch k := public.(type) {
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "crv": "Ed25519", "x": b64(k)}, nil
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())}, nil
+	}
+	return nil, errors.New("unsupported public key type")
+}
+
+// jwkThumbprint computes the RFC 7638 thumbprint used as the key ID
+func jwkThumbprint(public crypto.PublicKey) (string, error) {
+	fields, err := jwkFields(public)
+	if err != nil {
+		return "", err
+	}
+	// encoding/json sorts map keys, which is exactly the canonical form required
+	canonical, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// JWKS returns the public verification keys as a JSON Web Key Set. It is
+// empty when tokens are signed with the HS256 shared secret.
+func JWKS() (map[string]any, error) {
+	keys, err := getJWTKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	jwks := []map[string]string{}
+	for _, key := range keys.verify {
+		fields, err := jwkFields(key.public)
+		if err != nil {
+			return nil, err
+		}
+		fields["kid"] = key.kid
+		fields["alg"] = key.method.Alg()
+		fields["use"] = "sig"
+		jwks = append(jwks, fields)
+	}
+	return map[string]any{"keys": jwks}, nil
+}
+
+// signToken signs claims with the active key, stamping its kid header
+func signToken(claims jwt.MapClaims) (string, error) {
+	keys, err := getJWTKeys()
+	if err != nil {
+		return "", err
+	}
+
+	if keys.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(keys.hmacSecret)
+	}
+
+	token := jwt.NewWithClaims(keys.signing.method, claims)
+	token.Header["kid"] = keys.signing.kid
+	return token.SignedString(keys.signing.private)
+}
+
+// parseToken verifies a JWT signed by signToken, or by any retired key still
+// listed for verification, and returns its claims
+func parseToken(tokenString string) (jwt.MapClaims, error) {
+	keys, err := getJWTKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
+		if keys.signing == nil {
+			// Validate the alg is what you expect:
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, jwt.ErrSignatureInvalid
+			}
+			return keys.hmacSecret, nil
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keys.verify[kid]
+		if !ok || token.Method.Alg() != key.method.Alg() {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return key.public, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid or expired token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid token claims")
+	}
+	return claims, nil
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"strings"
+)
+
+// maxDiffEdits bounds the work done by diffLines. Texts further apart than
+// this are reported as the old lines removed and the new lines added.
+const maxDiffEdits = 2000
+
+const (
+	DiffEqual  = "equal"
+	DiffDelete = "delete"
+	DiffInsert = "insert"
+)
+
+// DiffLine is one line of a line-level diff. OldLine and NewLine are
+// 1-based and zero when the line is absent from that side.
+type DiffLine struct {
+	Op      string `json:"op"`
+	OldLine int    `json:"old_line,omitempty"`
+	NewLine int    `json:"new_line,omitempty"`
+	Text    string `json:"text"`
+}
+
+// splitLines splits text into lines, treating CRLF like LF
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines returns the shortest edit script turning a into b, using Myers'
+// algorithm on whatever remains after the common prefix and suffix
+func diffLines(a, b []string) []DiffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var lines []DiffLine
+	for i := 0; i < prefix; i++ {
+		lines = append(lines, DiffLine{Op: DiffEqual, OldLine: i + 1, NewLine: i + 1, Text: a[i]})
+	}
+
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	for _, line := range myersDiff(midA, midB) {
+		if line.OldLine != 0 {
+			line.OldLine += prefix
+		}
+		if line.NewLine != 0 {
+			line.NewLine += prefix
+		}
+		lines = append(lines, line)
+	}
+
+	for i := 0; i < suffix; i++ {
+		oldIndex, newIndex := len(a)-suffix+i, len(b)-suffix+i
+		lines = append(lines, DiffLine{Op: DiffEqual, OldLine: oldIndex + 1, NewLine: newIndex + 1, Text: a[oldIndex]})
+	}
+	return lines
+}
+
+func myersDiff(a, b []string) []DiffLine {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxDiffEdits)
+
+	// v[offset+k] is the furthest x reached on diagonal k; trace keeps the
+	// part of v each round started from so the path can be walked back
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	var trace [][]int
+
+	found := false
+	for d := 0; d <= limit && !found; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return replaceAll(a, b)
+	}
+
+	var reversed []DiffLine
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		// Round d's snapshot is indexed from diagonal -d-1
+		at := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, DiffLine{Op: DiffEqual, OldLine: x, NewLine: y, Text: a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, DiffLine{Op: DiffInsert, NewLine: y, Text: b[y-1]})
+			} else {
+				reversed = append(reversed, DiffLine{Op: DiffDelete, OldLine: x, Text: a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	lines := make([]DiffLine, len(reversed))
+	for i, line := range reversed {
+		lines[len(reversed)-1-i] = line
+	}
+	return lines
+}
+
+func replaceAll(a, b []string) []DiffLine {
+	lines := make([]DiffLine, 0, len(a)+len(b))
+	for i, text := range a {
+		lines = append(lines, DiffLine{Op: DiffDelete, OldLine: i + 1, Text: text})
+	}
+	for i, text := range b {
+		lines = append(lines, DiffLine{Op: DiffInsert, NewLine: i + 1, Text: text})
+	}
+	return lines
+}
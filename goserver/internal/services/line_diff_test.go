@@ -0,0 +1,177 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func equalLine(oldLine, newLine int, text string) DiffLine {
+	return DiffLine{Op: DiffEqual, OldLine: oldLine, NewLine: newLine, Text: text}
+}
+
+func deletedLine(oldLine int, text string) DiffLine {
+	return DiffLine{Op: DiffDelete, OldLine: oldLine, Text: text}
+}
+
+func insertedLine(newLine int, text string) DiffLine {
+	return DiffLine{Op: DiffInsert, NewLine: newLine, Text: text}
+}
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"one", []string{"one"}},
+		{"one\n", []string{"one"}},
+		{"one\ntwo", []string{"one", "two"}},
+		{"one\r\ntwo\r\n", []string{"one", "two"}},
+		{"one\n\ntwo", []string{"one", "", "two"}},
+	}
+	for _, tt := range tests {
+		if got := splitLines(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitLines(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want []DiffLine
+	}{
+		{
+			name: "both empty",
+		},
+		{
+			name: "empty to text",
+			new:  "a\nb",
+			want: []DiffLine{insertedLine(1, "a"), insertedLine(2, "b")},
+		},
+		{
+			name: "text to empty",
+			old:  "a\nb",
+			want: []DiffLine{deletedLine(1, "a"), deletedLine(2, "b")},
+		},
+		{
+			name: "unchanged",
+			old:  "a\nb",
+			new:  "a\nb",
+			want: []DiffLine{equalLine(1, 1, "a"), equalLine(2, 2, "b")},
+		},
+		{
+			name: "lines appended after a shared prefix",
+			old:  "a\nb",
+			new:  "a\nb\nc\nd",
+			want: []DiffLine{equalLine(1, 1, "a"), equalLine(2, 2, "b"), insertedLine(3, "c"), insertedLine(4, "d")},
+		},
+		{
+			name: "lines inserted before a shared suffix",
+			old:  "c\nd",
+			new:  "a\nb\nc\nd",
+			want: []DiffLine{insertedLine(1, "a"), insertedLine(2, "b"), equalLine(1, 3, "c"), equalLine(2, 4, "d")},
+		},
+		{
+			name: "middle line replaced",
+			old:  "a\nb\nc",
+			new:  "a\nx\nc",
+			want: []DiffLine{equalLine(1, 1, "a"), deletedLine(2, "b"), insertedLine(2, "x"), equalLine(3, 3, "c")},
+		},
+		{
+			name: "line moved",
+			old:  "a\nb\nc",
+			new:  "b\nc\na",
+			want: []DiffLine{deletedLine(1, "a"), equalLine(2, 1, "b"), equalLine(3, 2, "c"), insertedLine(3, "a")},
+		},
+		{
+			name: "CRLF matches LF",
+			old:  "a\r\nb\r\nc\r\n",
+			new:  "a\nB\nc\n",
+			want: []DiffLine{equalLine(1, 1, "a"), deletedLine(2, "b"), insertedLine(2, "B"), equalLine(3, 3, "c")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffLines(splitLines(tt.old), splitLines(tt.new))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffLines(%q, %q)\n got %v\nwant %v", tt.old, tt.new, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDiffLinesReplaysToNewText checks on larger inputs that the diff is a
+// valid edit script: the equal and deleted lines rebuild the old text and
+// the equal and inserted lines rebuild the new one.
+func TestDiffLinesReplaysToNewText(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 200; i++ {
+		a = append(a, fmt.Sprintf("line %d", i))
+		if i%7 != 0 {
+			b = append(b, fmt.Sprintf("line %d", i))
+		}
+		if i%11 == 0 {
+			b = append(b, fmt.Sprintf("added %d", i))
+		}
+	}
+
+	lines := diffLines(a, b)
+	var oldSide, newSide []string
+	edits := 0
+	for _, line := range lines {
+		switch line.Op {
+		case DiffEqual:
+			oldSide = append(oldSide, line.Text)
+			newSide = append(newSide, line.Text)
+		case DiffDelete:
+			oldSide = append(oldSide, line.Text)
+			edits++
+		case DiffInsert:
+			newSide = append(newSide, line.Text)
+			edits++
+		}
+	}
+	if !reflect.DeepEqual(oldSide, a) || !reflect.DeepEqual(newSide, b) {
+		t.Fatal("diff does not replay to the original texts")
+	}
+	// 29 lines dropped (every 7th) and 19 added (every 11th)
+	if edits != 29+19 {
+		t.Errorf("%d edits, want the minimal %d", edits, 29+19)
+	}
+}
+
+func TestDiffLinesFallsBackPastMaxEdits(t *testing.T) {
+	// Every line differs except one, so the edit distance is just over the limit
+	n := maxDiffEdits/2 + 2
+	var a, b []string
+	for i := 0; i < n; i++ {
+		a = append(a, fmt.Sprintf("old %d", i))
+		b = append(b, fmt.Sprintf("new %d", i))
+	}
+	// A shared line in the middle would be kept by a full diff
+	a[n/2], b[n/2] = "shared", "shared"
+	a = append([]string{"first"}, a...)
+	b = append([]string{"first"}, b...)
+
+	lines := diffLines(a, b)
+	if len(lines) != 1+2*n {
+		t.Fatalf("got %d lines, want %d", len(lines), 1+2*n)
+	}
+	if lines[0] != equalLine(1, 1, "first") {
+		t.Errorf("common prefix not kept: %v", lines[0])
+	}
+	for i, line := range lines[1 : 1+n] {
+		if line != deletedLine(i+2, a[i+1]) {
+			t.Fatalf("line %d = %v, want every old line deleted first", i+1, line)
+		}
+	}
+	for i, line := range lines[1+n:] {
+		if line != insertedLine(i+2, b[i+1]) {
+			t.Fatalf("line %d = %v, want every new line inserted after", 1+n+i, line)
+		}
+	}
+}
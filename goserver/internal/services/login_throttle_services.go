@@ -0,0 +1,194 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"goserver/internal/database"
+	"goserver/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// Reasons recorded in login_attempts
+const (
+	LoginSuccess            = "success"
+	LoginInvalidCredentials = "invalid_credentials"
+	LoginUnverified         = "unverified"
+	LoginThrottled          = "throttled"
+	LoginInvalidMFACode     = "invalid_mfa_code"
+)
+
+// throttlePolicy describes when repeated failures for one key start locking
+// it out. Each failure past the threshold doubles the lockout.
+type throttlePolicy struct {
+	threshold int
+	baseLock  time.Duration
+	maxLock   time.Duration
+}
+
+var (
+	userThrottle = throttlePolicy{threshold: 5, baseLock: time.Minute, maxLock: time.Hour}
+	ipThrottle   = throttlePolicy{threshold: 20, baseLock: time.Minute, maxLock: time.Hour}
+
+	// Failures older than this no longer count towards a lockout
+	throttleWindow = 15 * time.Minute
+)
+
+// LoginThrottledError is returned while a username or IP is locked out
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return "too many failed login attempts, please try again later"
+}
+
+func userThrottleKey(userName string) string {
+	return "user:" + strings.ToLower(strings.TrimSpace(userName))
+}
+
+// mfaThrottleKey counts wrong second-factor codes. It is kept apart from the
+// username's counter so passing the password step again does not reset it.
+func mfaThrottleKey(userName string) string {
+	return "mfa:" + strings.ToLower(strings.TrimSpace(userName))
+}
+
+// loginThrottleName maps a login identifier to the username it signs in as,
+// so a username and its email address share one lockout counter. Unknown
+// identifiers are throttled as typed.
+func loginThrottleName(identifier string) string {
+	var userName string
+	err := database.DB.Get(&userName, models.UserQueries.ResolveLoginName, identifier)
+	if err == sql.ErrNoRows {
+		return identifier
+	} else if err != nil {
+		log.Printf("Failed to resolve login name: %v", err)
+		return identifier
+	}
+	return userName
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// CheckLoginThrottle returns how long the caller must wait before the
+// username or IP may attempt another login. Zero means go ahead.
+func CheckLoginThrottle(userName, ip string) (time.Duration, error) {
+	var seconds float64
+	keys := pq.Array([]string{userThrottleKey(userName), mfaThrottleKey(userName), ipThrottleKey(ip)})
+	if err := database.DB.Get(&seconds, models.LoginAttemptQueries.GetLockRemaining, keys); err != nil {
+		return 0, fmt.Errorf("failed to check login throttle: %v", err)
+	}
+	return time.Duration(math.Ceil(seconds)) * time.Second, nil
+}
+
+// RecordLoginAttempt logs the attempt and updates the lockout counters. A
+// success clears the username's counter but not the IP's, so one valid
+// account can't be used to reset an attacker's budget.
+func RecordLoginAttempt(userName, ip string, success bool, reason string) {
+	if _, err := database.DB.Exec(models.LoginAttemptQueries.InsertAttempt, userName, ip, success, reason); err != nil {
+		log.Printf("Failed to record login attempt: %v", err)
+	}
+
+	if success {
+		if _, err := database.DB.Exec(models.LoginAttemptQueries.DeleteThrottle, userThrottleKey(userName)); err != nil {
+			log.Printf("Failed to reset login throttle: %v", err)
+		}
+		return
+	}
+
+	if reason == LoginThrottled {
+		return
+	}
+
+	registerFailure(userThrottleKey(userName), userThrottle)
+	registerFailure(ipThrottleKey(ip), ipThrottle)
+}
+
+func registerFailure(key string, policy throttlePolicy) {
+	var failures int
+	err := database.DB.Get(&failures, models.LoginAttemptQueries.IncrementThrottle, key, intervalString(throttleWindow))
+	if err != nil {
+		log.Printf("Failed to update login throttle: %v", err)
+		return
+	}
+
+	if failures < policy.threshold {
+		return
+	}
+
+	lock := policy.baseLock << min(failures-policy.threshold, 16)
+	if lock > policy.maxLock {
+		lock = policy.maxLock
+	}
+	if _, err := database.DB.Exec(models.LoginAttemptQueries.LockThrottle, key, intervalString(lock)); err != nil {
+		log.Printf("Failed to lock login throttle: %v", err)
+		return
+	}
+
+	log.Printf("Login locked for %s after %d failures (%v)", key, failures, lock)
+}
+
+// lockThrottleKey locks a key outright, whatever its failure count
+func lockThrottleKey(key string, lock time.Duration) {
+	if _, err := database.DB.Exec(models.LoginAttemptQueries.IncrementThrottle, key, intervalString(throttleWindow)); err != nil {
+		log.Printf("Failed to update login throttle: %v", err)
+		return
+	}
+	if _, err := database.DB.Exec(models.LoginAttemptQueries.LockThrottle, key, intervalString(lock)); err != nil {
+		log.Printf("Failed to lock login throttle: %v", err)
+	}
+}
+
+// RecordMFAAttempt logs a second-factor attempt. Failures count towards the
+// same lockout as wrong passwords; a success clears them.
+func RecordMFAAttempt(userName, ip string, success bool) {
+	reason := LoginSuccess
+	if !success {
+		reason = LoginInvalidMFACode
+	}
+	if _, err := database.DB.Exec(models.LoginAttemptQueries.InsertAttempt, userName, ip, success, reason); err != nil {
+		log.Printf("Failed to record login attempt: %v", err)
+	}
+
+	if success {
+		if _, err := database.DB.Exec(models.LoginAttemptQueries.DeleteThrottle, mfaThrottleKey(userName)); err != nil {
+			log.Printf("Failed to reset login throttle: %v", err)
+		}
+		return
+	}
+
+	registerFailure(mfaThrottleKey(userName), userThrottle)
+	registerFailure(ipThrottleKey(ip), ipThrottle)
+}
+
+// UnlockUserLogin clears the lockout for a username
+func UnlockUserLogin(userName string) error {
+	for _, key := range []string{userThrottleKey(userName), mfaThrottleKey(userName)} {
+		if _, err := database.DB.Exec(models.LoginAttemptQueries.DeleteThrottle, key); err != nil {
+			return fmt.Errorf("failed to unlock account: %v", err)
+		}
+	}
+	log.Printf("Login lockout cleared for %s", userName)
+	return nil
+}
+
+// GetLoginAttempts returns recent attempts, optionally filtered
+func GetLoginAttempts(userName, ip string, failedOnly bool, limit int) ([]models.DbLoginAttempt, error) {
+	attempts := []models.DbLoginAttempt{}
+	err := database.DB.Select(&attempts, models.LoginAttemptQueries.GetAttempts, userName, ip, failedOnly, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get login attempts: %v", err)
+	}
+	return attempts, nil
+}
+
+func intervalString(d time.Duration) string {
+	return fmt.Sprintf("%d seconds", int(d.Seconds()))
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"goserver/internal/config"
+	"goserver/internal/database"
+	"goserver/internal/models"
+)
+
+var ErrInvalidMagicLink = errors.New("invalid or expired sign-in link")
+
+var (
+	magicLinkEmailCooldown = NewCooldown(time.Minute)
+	magicLinkIPCooldown    = NewCooldown(10 * time.Second)
+)
+
+// RequestMagicLink emails a single-use sign-in link if the address belongs to
+// a verified account. It always returns a nonce for the caller to keep in the
+// requesting browser, so unknown addresses look the same as known ones. When
+// the email or IP is cooling down, the remaining wait is returned instead.
+func RequestMagicLink(email, ip string) (string, time.Duration, error) {
+	if ok, wait := magicLinkIPCooldown.Allow(ip); !ok {
+		return "", wait, nil
+	}
+	if ok, wait := magicLinkEmailCooldown.Allow(strings.ToLower(email)); !ok {
+		return "", wait, nil
+	}
+
+	nonce, err := generateOpaqueToken()
+	if err != nil {
+		return "", 0, err
+	}
+
+	user, err := GetUserByEmail(email)
+	if err != nil || !user.Approved {
+		log.Printf("Magic link requested for unknown or unverified email")
+		return nonce, 0, nil
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", 0, err
+	}
+
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Only the most recent link is valid
+	if _, err := tx.Exec(models.MagicLinkQueries.InvalidateForUser, user.ID); err != nil {
+		return "", 0, fmt.Errorf("failed to invalidate sign-in links: %v", err)
+	}
+
+	expiresAt := time.Now().Add(config.Load().MagicLinkTTL)
+	if _, err := tx.Exec(models.MagicLinkQueries.Insert, user.ID, hashToken(token), hashToken(nonce), expiresAt); err != nil {
+		return "", 0, fmt.Errorf("failed to store sign-in link: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", 0, fmt.Errorf("failed to commit sign-in link: %v", err)
+	}
+
+	return nonce, 0, SendMagicLinkEmail(user.Email, user.Username, token)
+}
+
+// RedeemMagicLink consumes a sign-in link. The nonce must come from the same
+// browser that requested the link.
+func RedeemMagicLink(token, nonce, ip string) (*models.DbUser, error) {
+	if token == "" || nonce == "" {
+		return nil, ErrInvalidMagicLink
+	}
+
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var link models.DbMagicLink
+	err = tx.Get(&link, models.MagicLinkQueries.GetByHashForUpdate, hashToken(token))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidMagicLink
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to look up sign-in link: %v", err)
+	}
+
+	if link.UsedAt != nil || time.Now().After(link.ExpiresAt) {
+		return nil, ErrInvalidMagicLink
+	}
+	if subtle.ConstantTimeCompare([]byte(link.NonceHash), []byte(hashToken(nonce))) != 1 {
+		return nil, ErrInvalidMagicLink
+	}
+
+	if _, err := tx.Exec(models.MagicLinkQueries.MarkUsed, link.ID); err != nil {
+		return nil, fmt.Errorf("failed to consume sign-in link: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit sign-in link: %v", err)
+	}
+
+	user, err := GetUserByID(link.UserID)
+	if err != nil {
+		return nil, ErrInvalidMagicLink
+	}
+
+	RecordLoginAttempt(user.Username, ip, true, LoginSuccess)
+	return user, nil
+}
@@ -2,8 +2,13 @@ package services
 
 import (
 	"fmt"
+	"html"
 	"log"
 	"os"
+	"strings"
+	"time"
+
+	"goserver/internal/config"
 
 	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
@@ -51,7 +56,7 @@ func SendWelcomeEmail(userEmail, userName string) error {
             <h1>Welcome %s!</h1>
             <p>Thank you for joining our platform.</p>
             <p>Best regards,<br>The Team</p>
-						<p><b>If you need permissions to comment on blog posts or download files, reply to this email.</b></p>
+						<p><b>If you need permissions to comment on blog posts or download files, sign in and request the role from your account.</b></p>
         `, userName),
 	})
 
@@ -90,6 +95,56 @@ func SendPasswordResetEmail(userEmail, resetToken string) error {
 	return nil
 }
 
+// SendMagicLinkEmail sends a single-use sign-in link
+func SendMagicLinkEmail(userEmail, userName, token string) error {
+	signInURL := fmt.Sprintf("%s/magic-link?token=%s", os.Getenv("FRONTEND_URL"), token)
+
+	err := SendEmail(EmailRequest{
+		To:      userEmail,
+		Subject: "Your sign-in link",
+		Text:    fmt.Sprintf("Hello %s, sign in by opening this link in the same browser you requested it from: %s", userName, signInURL),
+		HTML: fmt.Sprintf(`
+            <h2>Sign In</h2>
+            <p>Hello %s,</p>
+            <p>Click the button below to sign in. Open it in the same browser you requested it from.</p>
+            <a href="%s" style="background-color: #4CAF50; color: white; padding: 14px 20px; text-decoration: none; border-radius: 4px; display: inline-block;">Sign In</a>
+            <p>Or copy and paste this link: %s</p>
+            <p>This link can be used once and expires in %d minutes. If you didn't ask to sign in, you can ignore this email.</p>
+        `, html.EscapeString(userName), signInURL, signInURL, int(config.Load().MagicLinkTTL.Minutes())),
+	})
+
+	if err != nil {
+		log.Printf("Failed to send magic link email: %v", err)
+		return err
+	}
+
+	log.Printf("Magic link email sent to %s", userEmail)
+	return nil
+}
+
+// SendPasswordChangedEmail confirms a completed password reset
+func SendPasswordChangedEmail(userEmail, userName string) error {
+	err := SendEmail(EmailRequest{
+		To:      userEmail,
+		Subject: "Your password has been changed",
+		Text:    fmt.Sprintf("Hello %s, your password was just changed and all of your sessions were signed out. If this wasn't you, reset your password immediately.", userName),
+		HTML: fmt.Sprintf(`
+            <h2>Password Changed</h2>
+            <p>Hello %s,</p>
+            <p>Your password was just changed and all of your sessions were signed out.</p>
+            <p>If you didn't make this change, please reset your password immediately and let us know.</p>
+        `, userName),
+	})
+
+	if err != nil {
+		log.Printf("Failed to send password changed email: %v", err)
+		return err
+	}
+
+	log.Printf("Password changed email sent to %s", userEmail)
+	return nil
+}
+
 // SendBlogNotification sends a notification email about new blog posts
 func SendBlogNotification(userEmail, blogTitle, blogAuthor string) error {
 	err := SendEmail(EmailRequest{
@@ -101,7 +156,7 @@ func SendBlogNotification(userEmail, blogTitle, blogAuthor string) error {
             <h3>%s</h3>
             <p>Author: %s</p>
             <p>Check out the latest blog post on our platform.</p>
-        `, blogTitle, blogAuthor),
+        `, html.EscapeString(blogTitle), html.EscapeString(blogAuthor)),
 	})
 
 	if err != nil {
@@ -138,3 +193,169 @@ func SendVerificationEmail(userEmail, userName, verificationCode string) error {
 	log.Printf("Verification email sent to %s", userEmail)
 	return nil
 }
+
+// SendRoleRequestEmail tells an admin that a user has asked for a new role
+func SendRoleRequestEmail(adminEmail, userName, requestedRole, note string) error {
+	queueURL := fmt.Sprintf("%s/edit-users", os.Getenv("FRONTEND_URL"))
+	if note == "" {
+		note = "(no note)"
+	}
+
+	err := SendEmail(EmailRequest{
+		To:      adminEmail,
+		Subject: fmt.Sprintf("%s is requesting the %s role", userName, requestedRole),
+		Text:    fmt.Sprintf("%s has requested the %s role. Note: %s. Review it at %s", userName, requestedRole, note, queueURL),
+		HTML: fmt.Sprintf(`
+            <h2>New Role Request</h2>
+            <p><b>%s</b> has requested the <b>%s</b> role.</p>
+            <p>Note: %s</p>
+            <a href="%s">Review role requests</a>
+        `, html.EscapeString(userName), html.EscapeString(requestedRole), html.EscapeString(note), queueURL),
+	})
+
+	if err != nil {
+		log.Printf("Failed to send role request email: %v", err)
+		return err
+	}
+
+	log.Printf("Role request email sent to %s", adminEmail)
+	return nil
+}
+
+// SendRoleRequestOutcomeEmail tells a user whether their role request was approved
+func SendRoleRequestOutcomeEmail(userEmail, userName, requestedRole string, approved bool, reviewNote string) error {
+	outcome := "denied"
+	detail := "Your role has not been changed."
+	if approved {
+		outcome = "approved"
+		detail = "Please sign in again to use your new permissions."
+	}
+	if reviewNote != "" {
+		detail += " Note from the reviewer: " + reviewNote
+	}
+
+	err := SendEmail(EmailRequest{
+		To:      userEmail,
+		Subject: fmt.Sprintf("Your request for the %s role was %s", requestedRole, outcome),
+		Text:    fmt.Sprintf("Hello %s, your request for the %s role was %s. %s", userName, requestedRole, outcome, detail),
+		HTML: fmt.Sprintf(`
+            <h2>Role Request %s</h2>
+            <p>Hello %s,</p>
+            <p>Your request for the <b>%s</b> role was %s.</p>
+            <p>%s</p>
+        `, strings.ToUpper(outcome[:1])+outcome[1:], html.EscapeString(userName), html.EscapeString(requestedRole), outcome, html.EscapeString(detail)),
+	})
+
+	if err != nil {
+		log.Printf("Failed to send role request outcome email: %v", err)
+		return err
+	}
+
+	log.Printf("Role request outcome email sent to %s", userEmail)
+	return nil
+}
+
+// SendEmailChangeVerificationEmail asks the new address to confirm an email change
+func SendEmailChangeVerificationEmail(newEmail, userName, code string) error {
+	confirmURL := fmt.Sprintf("%s/confirm-email?code=%s", os.Getenv("FRONTEND_URL"), code)
+
+	err := SendEmail(EmailRequest{
+		To:      newEmail,
+		Subject: "Please confirm your new email address",
+		Text:    fmt.Sprintf("Hello %s, please confirm your new email address by clicking this link: %s", userName, confirmURL),
+		HTML: fmt.Sprintf(`
+            <h2>Confirm Your New Email</h2>
+            <p>Hello %s,</p>
+            <p>You asked to use this address for your account. Please confirm it by clicking the button below:</p>
+            <a href="%s" style="background-color: #4CAF50; color: white; padding: 14px 20px; text-decoration: none; border-radius: 4px; display: inline-block;">Confirm Email</a>
+            <p>Or copy and paste this link: %s</p>
+            <p>Your address won't change until you confirm. This link will expire in 24 hours.</p>
+        `, html.EscapeString(userName), confirmURL, confirmURL),
+	})
+
+	if err != nil {
+		log.Printf("Failed to send email change verification: %v", err)
+		return err
+	}
+
+	log.Printf("Email change verification sent to %s", newEmail)
+	return nil
+}
+
+// SendEmailChangeNoticeEmail warns the current address that a change was
+// requested, with a link to cancel it
+func SendEmailChangeNoticeEmail(oldEmail, userName, newEmail, cancelToken string) error {
+	cancelURL := fmt.Sprintf("%s/cancel-email-change?token=%s", os.Getenv("FRONTEND_URL"), cancelToken)
+
+	err := SendEmail(EmailRequest{
+		To:      oldEmail,
+		Subject: "Your account email is being changed",
+		Text:    fmt.Sprintf("Hello %s, someone asked to change your account email to %s. If this wasn't you, cancel it here: %s", userName, newEmail, cancelURL),
+		HTML: fmt.Sprintf(`
+            <h2>Email Change Requested</h2>
+            <p>Hello %s,</p>
+            <p>Someone asked to change the email address on your account to <b>%s</b>. It will only change once the new address is confirmed.</p>
+            <p>If this wasn't you, cancel the change and reset your password:</p>
+            <a href="%s" style="background-color: #f44336; color: white; padding: 14px 20px; text-decoration: none; border-radius: 4px; display: inline-block;">Cancel Change</a>
+            <p>Or copy and paste this link: %s</p>
+        `, html.EscapeString(userName), html.EscapeString(newEmail), cancelURL, cancelURL),
+	})
+
+	if err != nil {
+		log.Printf("Failed to send email change notice: %v", err)
+		return err
+	}
+
+	log.Printf("Email change notice sent to %s", oldEmail)
+	return nil
+}
+
+// SendEmailChangedEmail tells the old address that the change went through
+func SendEmailChangedEmail(oldEmail, userName string) error {
+	err := SendEmail(EmailRequest{
+		To:      oldEmail,
+		Subject: "Your account email has been changed",
+		Text:    fmt.Sprintf("Hello %s, the email address on your account was changed and this address will no longer receive account emails. If this wasn't you, contact us immediately.", userName),
+		HTML: fmt.Sprintf(`
+            <h2>Email Changed</h2>
+            <p>Hello %s,</p>
+            <p>The email address on your account was changed. This address will no longer receive account emails.</p>
+            <p>If you didn't make this change, please let us know immediately.</p>
+        `, html.EscapeString(userName)),
+	})
+
+	if err != nil {
+		log.Printf("Failed to send email changed notice: %v", err)
+		return err
+	}
+
+	log.Printf("Email changed notice sent to %s", oldEmail)
+	return nil
+}
+
+// SendInvitationEmail sends a single-use link for joining with a pre-assigned role
+func SendInvitationEmail(inviteeEmail, inviterName, role, token string, expiresAt time.Time) error {
+	acceptURL := fmt.Sprintf("%s/accept-invitation?token=%s", os.Getenv("FRONTEND_URL"), token)
+	expires := expiresAt.Format("January 2, 2006")
+
+	err := SendEmail(EmailRequest{
+		To:      inviteeEmail,
+		Subject: fmt.Sprintf("%s has invited you to join", inviterName),
+		Text:    fmt.Sprintf("%s has invited you to join as %s. Create your account here: %s. The invitation expires on %s.", inviterName, role, acceptURL, expires),
+		HTML: fmt.Sprintf(`
+            <h2>You're Invited!</h2>
+            <p><b>%s</b> has invited you to join with the <b>%s</b> role.</p>
+            <a href="%s" style="background-color: #4CAF50; color: white; padding: 14px 20px; text-decoration: none; border-radius: 4px; display: inline-block;">Create Your Account</a>
+            <p>Or copy and paste this link: %s</p>
+            <p>This link can be used once and expires on %s.</p>
+        `, html.EscapeString(inviterName), html.EscapeString(role), acceptURL, acceptURL, expires),
+	})
+
+	if err != nil {
+		log.Printf("Failed to send invitation email: %v", err)
+		return err
+	}
+
+	log.Printf("Invitation email sent to %s", inviteeEmail)
+	return nil
+}
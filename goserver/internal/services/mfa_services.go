@@ -0,0 +1,341 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"goserver/internal/config"
+	"goserver/internal/database"
+	"goserver/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Purposes for short-lived challenge tokens issued after the password step
+const (
+	MFAChallengeVerify = "mfa"
+	MFAChallengeEnroll = "mfa_enroll"
+)
+
+const (
+	mfaChallengeTTL   = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+// A challenge stops working after this many wrong codes, or once it has been
+// used to sign in
+var mfaChallengeThrottle = throttlePolicy{threshold: 5, baseLock: mfaChallengeTTL, maxLock: mfaChallengeTTL}
+
+var (
+	ErrInvalidMFAChallenge = errors.New("invalid or expired MFA challenge")
+	ErrInvalidMFACode      = errors.New("invalid authentication code")
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrMFANotPending       = errors.New("no two-factor enrollment in progress")
+	ErrMFARequiredByRole   = errors.New("two-factor authentication is required for your role")
+)
+
+// MFAEnrollment is returned when a user starts TOTP enrollment
+type MFAEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauthUrl"`
+}
+
+// LoginResult is the outcome of a successful first factor: either tokens, or
+// a challenge the client must answer through /auth/mfa.
+type LoginResult struct {
+	Tokens                *TokenPair `json:"-"`
+	MFARequired           bool       `json:"mfaRequired"`
+	MFAEnrollmentRequired bool       `json:"mfaEnrollmentRequired"`
+	MFAToken              string     `json:"mfaToken"`
+}
+
+// CompleteLogin issues tokens for a user who passed the first factor, unless
+// the account has an authenticator or its role demands one.
+func CompleteLogin(user *models.DbUser, client ClientInfo) (*LoginResult, error) {
+	mfaEnabled, err := MFAEnabled(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if mfaEnabled || RoleRequiresMFA(user.Role) {
+		purpose := MFAChallengeVerify
+		if !mfaEnabled {
+			purpose = MFAChallengeEnroll
+		}
+		mfaToken, err := GenerateMFAChallenge(user, purpose)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{
+			MFARequired:           mfaEnabled,
+			MFAEnrollmentRequired: !mfaEnabled,
+			MFAToken:              mfaToken,
+		}, nil
+	}
+
+	tokens, err := IssueTokenPair(user, client)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{Tokens: tokens}, nil
+}
+
+// GetUserMFA returns the user's MFA settings, or nil if they never enrolled
+func GetUserMFA(userID int) (*models.DbUserMFA, error) {
+	var mfa models.DbUserMFA
+	err := database.DB.Get(&mfa, models.MFAQueries.GetByUserID, userID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get MFA settings: %v", err)
+	}
+	return &mfa, nil
+}
+
+// MFAEnabled reports whether the user has a confirmed TOTP authenticator
+func MFAEnabled(userID int) (bool, error) {
+	mfa, err := GetUserMFA(userID)
+	if err != nil {
+		return false, err
+	}
+	return mfa != nil && mfa.Enabled, nil
+}
+
+// GenerateMFAChallenge issues the token a client exchanges, together with a
+// TOTP code, for real credentials.
+func GenerateMFAChallenge(user *models.DbUser, purpose string) (string, error) {
+	now := time.Now()
+	return signToken(jwt.MapClaims{
+		"purpose": purpose,
+		"user":    user.ID,
+		"jti":     uuid.New().String(),
+		"iat":     now.Unix(),
+		"exp":     now.Add(mfaChallengeTTL).Unix(),
+	})
+}
+
+// ParseMFAChallenge validates a challenge token and returns the user it was issued to
+func ParseMFAChallenge(tokenString, purpose string) (*models.DbUser, error) {
+	user, _, err := parseMFAChallenge(tokenString, purpose)
+	return user, err
+}
+
+func parseMFAChallenge(tokenString, purpose string) (*models.DbUser, string, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, "", ErrInvalidMFAChallenge
+	}
+
+	if p, _ := claims["purpose"].(string); p != purpose {
+		return nil, "", ErrInvalidMFAChallenge
+	}
+
+	challengeID, _ := claims["jti"].(string)
+	userID, ok := claims["user"].(float64)
+	if !ok || challengeID == "" {
+		return nil, "", ErrInvalidMFAChallenge
+	}
+
+	user, err := GetUserByID(int(userID))
+	if err != nil {
+		return nil, "", ErrInvalidMFAChallenge
+	}
+	return user, challengeID, nil
+}
+
+func mfaChallengeKey(challengeID string) string {
+	return "mfa_challenge:" + challengeID
+}
+
+// CompleteMFAChallenge answers a login challenge with a TOTP or recovery
+// code and returns the user to issue tokens to. Wrong codes count against
+// both the challenge and the account's login lockout, so the code cannot be
+// guessed by repeating the password step.
+func CompleteMFAChallenge(tokenString, code, recoveryCode, ip string) (*models.DbUser, error) {
+	user, challengeID, err := parseMFAChallenge(tokenString, MFAChallengeVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	var spent float64
+	keys := pq.Array([]string{mfaChallengeKey(challengeID)})
+	if err := database.DB.Get(&spent, models.LoginAttemptQueries.GetLockRemaining, keys); err != nil {
+		return nil, fmt.Errorf("failed to check MFA challenge: %v", err)
+	}
+	if spent > 0 {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	wait, err := CheckLoginThrottle(user.Username, ip)
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 {
+		RecordLoginAttempt(user.Username, ip, false, LoginThrottled)
+		return nil, &LoginThrottledError{RetryAfter: wait}
+	}
+
+	if err := VerifyMFA(user.ID, code, recoveryCode); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			RecordMFAAttempt(user.Username, ip, false)
+			registerFailure(mfaChallengeKey(challengeID), mfaChallengeThrottle)
+		}
+		return nil, err
+	}
+
+	RecordMFAAttempt(user.Username, ip, true)
+	// Spend the challenge so it can't be answered a second time
+	lockThrottleKey(mfaChallengeKey(challengeID), mfaChallengeTTL)
+	return user, nil
+}
+
+// BeginMFAEnrollment creates a pending TOTP secret for the user
+func BeginMFAEnrollment(user *models.DbUser) (*MFAEnrollment, error) {
+	if enabled, err := MFAEnabled(user.ID); err != nil {
+		return nil, err
+	} else if enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := database.DB.Exec(models.MFAQueries.UpsertPending, user.ID, secret); err != nil {
+		return nil, fmt.Errorf("failed to store MFA secret: %v", err)
+	}
+
+	return &MFAEnrollment{
+		Secret:     secret,
+		OTPAuthURL: totpProvisioningURI(secret, config.Load().MFAIssuer, user.Username),
+	}, nil
+}
+
+// ConfirmMFAEnrollment enables MFA once the user proves their authenticator
+// works, and returns one-time recovery codes. They are only shown once.
+func ConfirmMFAEnrollment(userID int, code string) ([]string, error) {
+	mfa, err := GetUserMFA(userID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa == nil {
+		return nil, ErrMFANotPending
+	}
+	if mfa.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	step, ok := validateTOTP(mfa.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(models.MFAQueries.Enable, userID, step); err != nil {
+		return nil, fmt.Errorf("failed to enable MFA: %v", err)
+	}
+
+	if _, err := tx.Exec(models.MFAQueries.DeleteRecoveryCodes, userID); err != nil {
+		return nil, fmt.Errorf("failed to reset recovery codes: %v", err)
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		recoveryCode, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(models.MFAQueries.InsertRecoveryCode, userID, hashToken(normalizeRecoveryCode(recoveryCode))); err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %v", err)
+		}
+		codes = append(codes, recoveryCode)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit MFA enrollment: %v", err)
+	}
+
+	return codes, nil
+}
+
+// VerifyMFA checks either a TOTP code or an unused recovery code. Each TOTP
+// step and each recovery code can only be used once.
+func VerifyMFA(userID int, code, recoveryCode string) error {
+	mfa, err := GetUserMFA(userID)
+	if err != nil {
+		return err
+	}
+	if mfa == nil || !mfa.Enabled {
+		return ErrInvalidMFACode
+	}
+
+	if recoveryCode != "" {
+		result, err := database.DB.Exec(models.MFAQueries.UseRecoveryCode, userID, hashToken(normalizeRecoveryCode(recoveryCode)))
+		if err != nil {
+			return fmt.Errorf("failed to use recovery code: %v", err)
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	step, ok := validateTOTP(mfa.TOTPSecret, code, time.Now())
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	result, err := database.DB.Exec(models.MFAQueries.AdvanceStep, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to record MFA code: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrInvalidMFACode // Replayed code
+	}
+	return nil
+}
+
+// DisableMFA removes the user's authenticator after verifying a current code
+func DisableMFA(user *models.DbUser, code string) error {
+	if RoleRequiresMFA(user.Role) {
+		return ErrMFARequiredByRole
+	}
+
+	if err := VerifyMFA(user.ID, code, ""); err != nil {
+		return err
+	}
+
+	if _, err := database.DB.Exec(models.MFAQueries.Disable, user.ID); err != nil {
+		return fmt.Errorf("failed to disable MFA: %v", err)
+	}
+	if _, err := database.DB.Exec(models.MFAQueries.DeleteRecoveryCodes, user.ID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %v", err)
+	}
+	return nil
+}
+
+// generateRecoveryCode returns a code such as "K7Q2M-XP4WD"
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code: %v", err)
+	}
+	code := totpEncoding.EncodeToString(b)[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
@@ -0,0 +1,411 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goserver/internal/config"
+	"goserver/internal/database"
+	"goserver/internal/models"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrOIDCUnknownProvider = errors.New("unknown identity provider")
+	ErrOIDCInvalidState    = errors.New("sign-in request expired, please try again")
+	ErrOIDCFailed          = errors.New("identity provider sign-in failed")
+	ErrOIDCEmailUnverified = errors.New("your identity provider did not supply a verified email address")
+	ErrOIDCLinkRequired    = errors.New("an account with this email already exists; sign in with your password and link this provider from your account")
+	ErrOIDCIdentityInUse   = errors.New("this identity is already linked to another account")
+)
+
+// oidcLogin is what we remember between redirecting to the provider and its
+// callback. The state parameter is the key it is stored under. LinkUserID is
+// set when a signed-in user is linking the provider to their account.
+type oidcLogin struct {
+	Provider   string
+	Nonce      string
+	Verifier   string
+	DeviceID   string
+	LinkUserID int
+}
+
+// OIDCResult is the outcome of a provider callback: a user to sign in, or
+// confirmation that the identity was linked to the account that asked.
+type OIDCResult struct {
+	User     *models.DbUser
+	DeviceID string
+	Linked   bool
+}
+
+// oidcClaims are the ID token claims used to link or provision an account
+type oidcClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     any    `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Nonce             string `json:"nonce"`
+}
+
+// OIDCProviderInfo is the public description of a configured provider
+type OIDCProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type oidcClient struct {
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+var (
+	oidcLogins = newCeremonyStore[oidcLogin](10 * time.Minute)
+
+	oidcClientsMu sync.Mutex
+	oidcClients   = map[string]*oidcClient{}
+)
+
+// GetOIDCProviders lists the identity providers users can sign in with
+func GetOIDCProviders() []OIDCProviderInfo {
+	providers := []OIDCProviderInfo{}
+	for _, p := range config.Load().OIDCProviders {
+		providers = append(providers, OIDCProviderInfo{Name: p.Name, DisplayName: p.DisplayName})
+	}
+	return providers
+}
+
+// OIDCCallbackURL is the redirect URI registered with the provider
+func OIDCCallbackURL(cfg *config.Config, provider string) string {
+	return cfg.OIDCRedirectBase + "/api/v1/auth/oidc/" + provider + "/callback"
+}
+
+// getOIDCClient runs discovery for a provider the first time it is used.
+// Failed discovery is not cached so a provider that was briefly down recovers.
+func getOIDCClient(name string) (*oidcClient, error) {
+	oidcClientsMu.Lock()
+	defer oidcClientsMu.Unlock()
+
+	if client, ok := oidcClients[name]; ok {
+		return client, nil
+	}
+
+	cfg := config.Load()
+	p, ok := cfg.OIDCProvider(name)
+	if !ok {
+		return nil, ErrOIDCUnknownProvider
+	}
+
+	// The provider keeps this context for fetching signing keys later on, so
+	// it must outlive the request that triggered discovery
+	provider, err := oidc.NewProvider(context.Background(), p.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover %s: %v", p.Issuer, err)
+	}
+
+	client := &oidcClient{
+		oauth2: oauth2.Config{
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  OIDCCallbackURL(cfg, p.Name),
+			Scopes:       p.Scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: p.ClientID}),
+	}
+	oidcClients[name] = client
+	return client, nil
+}
+
+// BeginOIDCLogin returns the provider URL to send the browser to, and the
+// state value the caller should also bind to the browser (e.g. in a cookie).
+func BeginOIDCLogin(providerName, deviceID string) (string, string, error) {
+	return beginOIDC(oidcLogin{Provider: providerName, DeviceID: deviceID})
+}
+
+// BeginOIDCLink starts the same redirect for a signed-in user; the callback
+// links the provider identity to their account instead of signing in.
+func BeginOIDCLink(providerName string, user *models.DbUser) (string, string, error) {
+	return beginOIDC(oidcLogin{Provider: providerName, LinkUserID: user.ID})
+}
+
+func beginOIDC(login oidcLogin) (string, string, error) {
+	client, err := getOIDCClient(login.Provider)
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce, err := generateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	login.Nonce = nonce
+	login.Verifier = oauth2.GenerateVerifier()
+
+	state, err := oidcLogins.Put(login)
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL := client.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(login.Verifier))
+	return authURL, state, nil
+}
+
+// FinishOIDCLogin exchanges the authorization code, validates the ID token
+// and either links the identity to the user who started a link, or returns
+// the linked (or newly provisioned) user to sign in.
+func FinishOIDCLogin(ctx context.Context, providerName, state, code string) (*OIDCResult, error) {
+	login, claims, err := verifyOIDCCallback(ctx, providerName, state, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if login.LinkUserID != 0 {
+		if err := linkOIDCIdentity(login.LinkUserID, providerName, claims); err != nil {
+			return nil, err
+		}
+		return &OIDCResult{Linked: true}, nil
+	}
+
+	user, err := resolveOIDCUser(providerName, claims)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCResult{User: user, DeviceID: login.DeviceID}, nil
+}
+
+// verifyOIDCCallback checks the state, redeems the code with its PKCE
+// verifier and returns the validated ID token claims
+func verifyOIDCCallback(ctx context.Context, providerName, state, code string) (*oidcLogin, *oidcClaims, error) {
+	login, ok := oidcLogins.Take(state)
+	if !ok || login.Provider != providerName {
+		return nil, nil, ErrOIDCInvalidState
+	}
+
+	client, err := getOIDCClient(providerName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	token, err := client.oauth2.Exchange(ctx, code, oauth2.VerifierOption(login.Verifier))
+	if err != nil {
+		log.Printf("OIDC code exchange with %s failed: %v", providerName, err)
+		return nil, nil, ErrOIDCFailed
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		log.Printf("OIDC token response from %s had no id_token", providerName)
+		return nil, nil, ErrOIDCFailed
+	}
+
+	idToken, err := client.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		log.Printf("OIDC ID token from %s rejected: %v", providerName, err)
+		return nil, nil, ErrOIDCFailed
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, nil, fmt.Errorf("failed to read ID token claims: %v", err)
+	}
+	if claims.Nonce != login.Nonce {
+		log.Printf("OIDC nonce mismatch from %s", providerName)
+		return nil, nil, ErrOIDCFailed
+	}
+	return &login, &claims, nil
+}
+
+// resolveOIDCUser finds the account for a provider identity. Known identities
+// sign in directly; otherwise the identity is linked to the account with the
+// same verified email, or a new User-role account is created for it.
+// Administrative accounts are never linked this way.
+func resolveOIDCUser(provider string, claims *oidcClaims) (*models.DbUser, error) {
+	var identity models.DbUserIdentity
+	err := database.DB.Get(&identity, models.IdentityQueries.GetBySubject, provider, claims.Subject)
+	if err == nil {
+		if _, err := database.DB.Exec(models.IdentityQueries.TouchLogin, claims.Email, identity.ID); err != nil {
+			return nil, fmt.Errorf("failed to update identity: %v", err)
+		}
+		return GetUserByID(identity.UserID)
+	} else if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to look up identity: %v", err)
+	}
+
+	if claims.Email == "" || !claimIsTrue(claims.EmailVerified) {
+		return nil, ErrOIDCEmailUnverified
+	}
+
+	user, err := GetUserByEmail(claims.Email)
+	if err == nil {
+		if requiresExplicitOIDCLink(user) {
+			return nil, ErrOIDCLinkRequired
+		}
+		if !user.Approved {
+			if err := claimUnverifiedAccount(user); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		if user, err = provisionOIDCUser(claims); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := database.DB.Exec(models.IdentityQueries.Insert, user.ID, provider, claims.Subject, claims.Email); err != nil {
+		return nil, fmt.Errorf("failed to link identity: %v", err)
+	}
+	log.Printf("Linked %s identity to user %d", provider, user.ID)
+	return user, nil
+}
+
+// requiresExplicitOIDCLink reports whether the account can administer others.
+// A provider identity only attaches to such accounts through the signed-in
+// link flow, never because the email address matches.
+func requiresExplicitOIDCLink(user *models.DbUser) bool {
+	return isAdminRole(user.Role)
+}
+
+// claimUnverifiedAccount hands an account whose email was never verified to
+// the provider identity that has now proven the address. Whoever signed up
+// chose the password without owning the address, so it is replaced with a
+// random one and anything issued to the account is revoked.
+func claimUnverifiedAccount(user *models.DbUser) error {
+	password, err := generateOpaqueToken()
+	if err != nil {
+		return err
+	}
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(models.UserQueries.UpdatePassword, passwordHash, user.ID); err != nil {
+		return fmt.Errorf("failed to reset password: %v", err)
+	}
+	if err := tx.QueryRowx(models.UserQueries.ApproveUser, user.ID).Scan(&user.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to approve user: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit account claim: %v", err)
+	}
+	user.Approved = true
+
+	log.Printf("Unverified user %d claimed by a verified provider identity; password reset", user.ID)
+	return RevokeAllUserTokens(user.ID)
+}
+
+// linkOIDCIdentity attaches a provider identity to a signed-in user who
+// asked for it. The provider's email does not have to match the account.
+func linkOIDCIdentity(userID int, provider string, claims *oidcClaims) error {
+	var identity models.DbUserIdentity
+	err := database.DB.Get(&identity, models.IdentityQueries.GetBySubject, provider, claims.Subject)
+	if err == nil {
+		if identity.UserID != userID {
+			return ErrOIDCIdentityInUse
+		}
+		return nil
+	} else if err != sql.ErrNoRows {
+		return fmt.Errorf("failed to look up identity: %v", err)
+	}
+
+	if _, err := database.DB.Exec(models.IdentityQueries.Insert, userID, provider, claims.Subject, claims.Email); err != nil {
+		return fmt.Errorf("failed to link identity: %v", err)
+	}
+	log.Printf("User %d linked a %s identity", userID, provider)
+	return nil
+}
+
+var usernameDisallowed = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// provisionOIDCUser creates an approved account for a first-time provider
+// sign-in. Its password is random so it can only be used after a reset.
+func provisionOIDCUser(claims *oidcClaims) (*models.DbUser, error) {
+	password, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	base := claims.PreferredUsername
+	if at := strings.Index(base, "@"); at >= 0 {
+		base = base[:at]
+	}
+	if base == "" {
+		base = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+	userName, err := availableUsername(base)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.DbUser{
+		Username: userName,
+		Email:    claims.Email,
+		Role:     models.USER_ROLES["USER"].Name,
+		Approved: true,
+	}
+	err = database.DB.QueryRowx(models.UserQueries.InsertApproved, user.Username, passwordHash, user.Email, user.Role).
+		Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %v", err)
+	}
+
+	SendWelcomeEmail(user.Email, user.Username)
+	return user, nil
+}
+
+// availableUsername turns base into a valid username, adding a number if it
+// is already taken
+func availableUsername(base string) (string, error) {
+	base = usernameDisallowed.ReplaceAllString(base, "")
+	if len(base) > 28 {
+		base = base[:28]
+	}
+	for len(base) < 3 {
+		base += "_"
+	}
+
+	candidate := base
+	for i := 2; i < 1000; i++ {
+		var existing models.DbUser
+		err := database.DB.Get(&existing, models.UserQueries.GetByName, candidate)
+		if err == sql.ErrNoRows {
+			return candidate, nil
+		} else if err != nil {
+			return "", fmt.Errorf("error checking for existing user: %v", err)
+		}
+		candidate = base + strconv.Itoa(i)
+	}
+	return "", errors.New("could not find an available username")
+}
+
+// claimIsTrue accepts email_verified as a boolean or, as some providers send
+// it, the string "true"
+func claimIsTrue(v any) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case string:
+		return b == "true"
+	}
+	return false
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"goserver/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	mockOIDCProvider = "mock"
+	mockClientID     = "goserver-test"
+)
+
+// mockOIDC is an in-process OpenID Connect provider. The test plays the
+// browser: it reads the authorization URL and asks the mock for a code.
+type mockOIDC struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+	key       *rsa.PrivateKey
+}
+
+func newMockOIDC(t *testing.T) *mockOIDC {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockOIDC{key: key, grants: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                m.server.URL,
+			"authorization_endpoint":                m.server.URL + "/authorize",
+			"token_endpoint":                        m.server.URL + "/token",
+			"jwks_uri":                              m.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", m.token)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+
+	t.Setenv("OIDC_PROVIDERS", mockOIDCProvider)
+	t.Setenv("OIDC_MOCK_ISSUER", m.server.URL)
+	t.Setenv("OIDC_MOCK_CLIENT_ID", mockClientID)
+	t.Setenv("OIDC_MOCK_CLIENT_SECRET", "secret")
+
+	// Discovery results are cached per provider name
+	oidcClientsMu.Lock()
+	oidcClients = map[string]*oidcClient{}
+	oidcClientsMu.Unlock()
+	return m
+}
+
+func (m *mockOIDC) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	m.mu.Lock()
+	grant, ok := m.grants[r.PostForm.Get("code")]
+	delete(m.grants, r.PostForm.Get("code"))
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, grant.claims)
+	token.Header["kid"] = "test"
+	idToken, err := token.SignedString(grant.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{
+		"access_token": "provider-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// authorize stands in for the user signing in at the provider. It returns
+// the state and a code for an ID token with the given claim overrides.
+func (m *mockOIDC) authorize(t *testing.T, authURL string, overrides jwt.MapClaims, key *rsa.PrivateKey) (string, string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("authorization URL did not use PKCE: %s", authURL)
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            m.server.URL,
+		"aud":            mockClientID,
+		"sub":            "subject-1",
+		"email":          "person@example.com",
+		"email_verified": true,
+		"nonce":          q.Get("nonce"),
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+	}
+	for k, v := range overrides {
+		claims[k] = v
+	}
+	if key == nil {
+		key = m.key
+	}
+
+	code, err := generateOpaqueToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	m.grants[code] = mockGrant{challenge: q.Get("code_challenge"), claims: claims, key: key}
+	m.mu.Unlock()
+	return q.Get("state"), code
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func TestOIDCCallbackReturnsVerifiedClaims(t *testing.T) {
+	m := newMockOIDC(t)
+
+	authURL, state, err := BeginOIDCLogin(mockOIDCProvider, "device-1")
+	if err != nil {
+		t.Fatalf("BeginOIDCLogin: %v", err)
+	}
+	urlState, code := m.authorize(t, authURL, nil, nil)
+	if urlState != state {
+		t.Fatalf("state in URL %q, want %q", urlState, state)
+	}
+
+	login, claims, err := verifyOIDCCallback(context.Background(), mockOIDCProvider, state, code)
+	if err != nil {
+		t.Fatalf("verifyOIDCCallback: %v", err)
+	}
+	if claims.Subject != "subject-1" || claims.Email != "person@example.com" || !claimIsTrue(claims.EmailVerified) {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if login.DeviceID != "device-1" || login.LinkUserID != 0 {
+		t.Errorf("unexpected login %+v", login)
+	}
+
+	// The state is single use
+	if _, _, err := verifyOIDCCallback(context.Background(), mockOIDCProvider, state, code); !errors.Is(err, ErrOIDCInvalidState) {
+		t.Errorf("replayed state: got %v, want ErrOIDCInvalidState", err)
+	}
+}
+
+func TestOIDCLinkRemembersUser(t *testing.T) {
+	m := newMockOIDC(t)
+
+	authURL, _, err := BeginOIDCLink(mockOIDCProvider, &models.DbUser{ID: 42})
+	if err != nil {
+		t.Fatalf("BeginOIDCLink: %v", err)
+	}
+	state, code := m.authorize(t, authURL, nil, nil)
+
+	login, _, err := verifyOIDCCallback(context.Background(), mockOIDCProvider, state, code)
+	if err != nil {
+		t.Fatalf("verifyOIDCCallback: %v", err)
+	}
+	if login.LinkUserID != 42 {
+		t.Errorf("LinkUserID = %d, want 42", login.LinkUserID)
+	}
+}
+
+func TestOIDCCallbackRejectsBadResponses(t *testing.T) {
+	m := newMockOIDC(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		overrides jwt.MapClaims
+		key       *rsa.PrivateKey
+		provider  string
+		tamper    func(code string) string
+		want      error
+	}{
+		{name: "nonce mismatch", overrides: jwt.MapClaims{"nonce": "other"}, want: ErrOIDCFailed},
+		{name: "wrong audience", overrides: jwt.MapClaims{"aud": "someone-else"}, want: ErrOIDCFailed},
+		{name: "wrong issuer", overrides: jwt.MapClaims{"iss": "https://evil.example"}, want: ErrOIDCFailed},
+		{name: "expired", overrides: jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}, want: ErrOIDCFailed},
+		{name: "unknown signing key", key: otherKey, want: ErrOIDCFailed},
+		{name: "unknown code", tamper: func(string) string { return "not-a-code" }, want: ErrOIDCFailed},
+		{name: "other provider's callback", provider: "other", want: ErrOIDCInvalidState},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authURL, _, err := BeginOIDCLogin(mockOIDCProvider, "")
+			if err != nil {
+				t.Fatalf("BeginOIDCLogin: %v", err)
+			}
+			state, code := m.authorize(t, authURL, tt.overrides, tt.key)
+			if tt.tamper != nil {
+				code = tt.tamper(code)
+			}
+			provider := mockOIDCProvider
+			if tt.provider != "" {
+				provider = tt.provider
+			}
+
+			_, _, err = verifyOIDCCallback(context.Background(), provider, state, code)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequiresExplicitOIDCLink(t *testing.T) {
+	roleCache.mu.Lock()
+	saved := roleCache.roles
+	roleCache.roles = map[string]models.DbRole{
+		"User":     {Name: "User", Level: 1, Permissions: []string{models.PermCommentCreate}},
+		"Admin":    {Name: "Admin", Level: 3, Permissions: []string{models.PermUsersManage, models.PermRolesManage}},
+		"Helpdesk": {Name: "Helpdesk", Level: 2, Permissions: []string{models.PermUsersImpersonate}},
+	}
+	roleCache.mu.Unlock()
+	t.Cleanup(func() {
+		roleCache.mu.Lock()
+		roleCache.roles = saved
+		roleCache.mu.Unlock()
+	})
+
+	for role, want := range map[string]bool{"User": false, "Admin": true, "Helpdesk": true, "Missing": false} {
+		if got := requiresExplicitOIDCLink(&models.DbUser{Role: role}); got != want {
+			t.Errorf("requiresExplicitOIDCLink(%s) = %v, want %v", role, got, want)
+		}
+	}
+}
+
+func TestClaimIsTrue(t *testing.T) {
+	for _, tt := range []struct {
+		value any
+		want  bool
+	}{
+		{true, true},
+		{"true", true},
+		{false, false},
+		{"false", false},
+		{"yes", false},
+		{nil, false},
+		{1.0, false},
+	} {
+		if got := claimIsTrue(tt.value); got != tt.want {
+			t.Errorf("claimIsTrue(%#v) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
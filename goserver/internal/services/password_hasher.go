@@ -0,0 +1,162 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"goserver/internal/config"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordHasher is one stored password format. The format is recognised
+// from the hash itself, so old hashes keep working after the default changes.
+type passwordHasher interface {
+	// matches reports whether an encoded hash uses this format
+	matches(encoded string) bool
+	hash(password string) (string, error)
+	verify(encoded, password string) (bool, error)
+	// needsRehash reports whether the hash should be replaced at next login
+	needsRehash(encoded string) bool
+}
+
+var (
+	errUnknownPasswordHash = errors.New("unrecognised password hash format")
+	errVerifyOnlyHasher    = errors.New("password format is only supported for verification")
+)
+
+// passwordHashers lists supported formats; the first one hashes new passwords
+var passwordHashers = []passwordHasher{argon2idHasher{}, bcryptHasher{}}
+
+// hashPassword returns the hash stored in user_password
+func hashPassword(password string) (string, error) {
+	return passwordHashers[0].hash(password)
+}
+
+// verifyPassword checks a password against its stored hash, and reports
+// whether the hash should be upgraded to the current format and parameters.
+func verifyPassword(encoded, password string) (ok bool, rehash bool, err error) {
+	for _, h := range passwordHashers {
+		if !h.matches(encoded) {
+			continue
+		}
+		ok, err = h.verify(encoded, password)
+		if err != nil || !ok {
+			return false, false, err
+		}
+		return true, h != passwordHashers[0] || h.needsRehash(encoded), nil
+	}
+	return false, false, errUnknownPasswordHash
+}
+
+// argon2idHasher produces PHC strings such as
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+type argon2idHasher struct{}
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	keyLength   uint32
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+func currentArgon2Params() argon2Params {
+	cfg := config.Load().PasswordHashing
+	return argon2Params{
+		memory:      uint32(max(cfg.MemoryKiB, 8*1024)),
+		iterations:  uint32(max(cfg.Iterations, 1)),
+		parallelism: uint8(min(max(cfg.Parallelism, 1), 255)),
+		keyLength:   argon2KeyLength,
+	}
+}
+
+func (argon2idHasher) matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (argon2idHasher) hash(password string) (string, error) {
+	p := currentArgon2Params()
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("error hashing password: %v", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.parallelism, p.keyLength)
+	b64 := base64.RawStdEncoding.EncodeToString
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.memory, p.iterations, p.parallelism, b64(salt), b64(key)), nil
+}
+
+func (argon2idHasher) verify(encoded, password string) (bool, error) {
+	p, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	candidate := argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.parallelism, p.keyLength)
+	return subtle.ConstantTimeCompare(key, candidate) == 1, nil
+}
+
+func (argon2idHasher) needsRehash(encoded string) bool {
+	p, _, _, err := decodeArgon2id(encoded)
+	return err != nil || p != currentArgon2Params()
+}
+
+func decodeArgon2id(encoded string) (argon2Params, []byte, []byte, error) {
+	var p argon2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, errUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, errUnknownPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return p, nil, nil, errUnknownPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, errUnknownPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, errUnknownPasswordHash
+	}
+	p.keyLength = uint32(len(key))
+	return p, salt, key, nil
+}
+
+// bcryptHasher verifies hashes created before argon2id was introduced
+type bcryptHasher struct{}
+
+func (bcryptHasher) matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (bcryptHasher) hash(string) (string, error) {
+	return "", errVerifyOnlyHasher
+}
+
+func (bcryptHasher) verify(encoded, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (bcryptHasher) needsRehash(string) bool {
+	return true
+}
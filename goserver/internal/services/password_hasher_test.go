@@ -0,0 +1,167 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheapArgon2 keeps the hashing parameters at the minimum so tests stay fast
+func cheapArgon2(t *testing.T) {
+	t.Helper()
+	t.Setenv("ARGON2_MEMORY_KIB", "8192")
+	t.Setenv("ARGON2_ITERATIONS", "1")
+	t.Setenv("ARGON2_PARALLELISM", "1")
+}
+
+func TestVerifyPasswordArgon2id(t *testing.T) {
+	cheapArgon2(t)
+	encoded, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("hashPassword: %v", err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=8192,t=1,p=1$") {
+		t.Fatalf("unexpected hash %q", encoded)
+	}
+
+	ok, rehash, err := verifyPassword(encoded, "correct horse")
+	if err != nil || !ok || rehash {
+		t.Errorf("right password: ok=%v rehash=%v err=%v, want ok without rehash", ok, rehash, err)
+	}
+	ok, rehash, err = verifyPassword(encoded, "wrong horse")
+	if err != nil || ok || rehash {
+		t.Errorf("wrong password: ok=%v rehash=%v err=%v, want rejected", ok, rehash, err)
+	}
+}
+
+func TestVerifyPasswordFlagsChangedArgon2Params(t *testing.T) {
+	cheapArgon2(t)
+	encoded, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("hashPassword: %v", err)
+	}
+
+	for _, env := range []string{"ARGON2_MEMORY_KIB", "ARGON2_ITERATIONS", "ARGON2_PARALLELISM"} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, "16384")
+			ok, rehash, err := verifyPassword(encoded, "correct horse")
+			if err != nil || !ok {
+				t.Fatalf("ok=%v err=%v, want the old hash to keep verifying", ok, err)
+			}
+			if !rehash {
+				t.Error("hash with old parameters not flagged for rehash")
+			}
+		})
+	}
+}
+
+func TestVerifyPasswordBcrypt(t *testing.T) {
+	cheapArgon2(t)
+	hashed, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, rehash, err := verifyPassword(string(hashed), "correct horse")
+	if err != nil || !ok || !rehash {
+		t.Errorf("right password: ok=%v rehash=%v err=%v, want ok and rehash", ok, rehash, err)
+	}
+	ok, rehash, err = verifyPassword(string(hashed), "wrong horse")
+	if err != nil || ok || rehash {
+		t.Errorf("wrong password: ok=%v rehash=%v err=%v, want rejected", ok, rehash, err)
+	}
+}
+
+func TestVerifyPasswordMalformedHashFailsClosed(t *testing.T) {
+	cheapArgon2(t)
+	encoded, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("hashPassword: %v", err)
+	}
+	parts := strings.Split(encoded, "$")
+
+	tests := map[string]string{
+		"empty":             "",
+		"plain text":        "correct horse",
+		"unknown algorithm": "$argon2i$v=19$m=8192,t=1,p=1$" + parts[4] + "$" + parts[5],
+		"missing key":       strings.Join(parts[:5], "$"),
+		"extra field":       encoded + "$extra",
+		"wrong version":     strings.Replace(encoded, "v=19", "v=16", 1),
+		"bad params":        strings.Replace(encoded, "m=8192,t=1,p=1", "m=lots", 1),
+		"bad salt":          strings.Replace(encoded, parts[4], "!!!", 1),
+		"bad key":           strings.Replace(encoded, parts[5], "!!!", 1),
+		"truncated bcrypt":  "$2a$04$tooshort",
+	}
+	for name, stored := range tests {
+		t.Run(name, func(t *testing.T) {
+			ok, rehash, err := verifyPassword(stored, "correct horse")
+			if ok || rehash {
+				t.Errorf("ok=%v rehash=%v, want rejected", ok, rehash)
+			}
+			if err == nil {
+				t.Error("no error for a malformed hash")
+			}
+		})
+	}
+
+	if _, _, err := verifyPassword("correct horse", "correct horse"); !errors.Is(err, errUnknownPasswordHash) {
+		t.Errorf("plain text: got %v, want errUnknownPasswordHash", err)
+	}
+}
+
+func TestLoadBreachedPasswords(t *testing.T) {
+	list := strings.Join([]string{
+		"password123",
+		"  letmein  ",
+		"qwerty\r",
+		"",
+		// sha1("hunter2") with a count, as in Have I Been Pwned downloads
+		"F3BBBD66A63D4BF1747940578EC3D0103530E21D:17",
+		// sha1("trustno1") in lower case without a count
+		"e68e11be8b70e435c65aef8ba9798ff7775c361e",
+	}, "\n")
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte(list), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BREACHED_PASSWORDS_FILE", path)
+
+	breachedPasswords.mu.Lock()
+	saved := breachedPasswords.hashes
+	breachedPasswords.mu.Unlock()
+	t.Cleanup(func() {
+		breachedPasswords.mu.Lock()
+		breachedPasswords.hashes = saved
+		breachedPasswords.mu.Unlock()
+	})
+
+	if err := LoadBreachedPasswords(); err != nil {
+		t.Fatalf("LoadBreachedPasswords: %v", err)
+	}
+	for password, want := range map[string]bool{
+		"password123":            true,
+		"  letmein  ":            true,
+		"letmein":                false,
+		"qwerty":                 true,
+		"hunter2":                true,
+		"trustno1":               true,
+		"Hunter2":                false,
+		"":                       false,
+		"a fine long passphrase": false,
+	} {
+		if got := IsBreachedPassword(password); got != want {
+			t.Errorf("IsBreachedPassword(%q) = %v, want %v", password, got, want)
+		}
+	}
+}
+
+func TestLoadBreachedPasswordsMissingFile(t *testing.T) {
+	t.Setenv("BREACHED_PASSWORDS_FILE", filepath.Join(t.TempDir(), "missing.txt"))
+	if err := LoadBreachedPasswords(); err == nil {
+		t.Error("missing breach list did not fail")
+	}
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"goserver/internal/database"
+	"goserver/internal/models"
+)
+
+const passwordResetTTL = time.Hour
+
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+var (
+	passwordResetEmailCooldown = NewCooldown(5 * time.Minute)
+	passwordResetIPCooldown    = NewCooldown(time.Minute)
+)
+
+// RequestPasswordReset emails a single-use reset link if the address belongs
+// to an account. The lookup and email happen in the background, so known and
+// unknown addresses answer alike and in the same time. When the email or IP
+// is cooling down, the remaining wait is returned instead.
+func RequestPasswordReset(email, ip string) time.Duration {
+	if ok, wait := passwordResetIPCooldown.Allow(ip); !ok {
+		return wait
+	}
+	if ok, wait := passwordResetEmailCooldown.Allow(strings.ToLower(email)); !ok {
+		return wait
+	}
+
+	go func() {
+		if err := sendPasswordReset(email); err != nil {
+			log.Printf("Password reset request failed: %v", err)
+		}
+	}()
+	return 0
+}
+
+// sendPasswordReset replaces the account's reset link and emails it.
+// Unknown addresses are ignored.
+func sendPasswordReset(email string) error {
+	user, err := GetUserByEmail(email)
+	if err != nil {
+		log.Printf("Password reset requested for unknown email")
+		return nil
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Only the most recent link is valid
+	if _, err := tx.Exec(models.PasswordResetQueries.InvalidateForUser, user.ID); err != nil {
+		return fmt.Errorf("failed to invalidate reset tokens: %v", err)
+	}
+
+	expiresAt := time.Now().Add(passwordResetTTL)
+	if _, err := tx.Exec(models.PasswordResetQueries.Insert, user.ID, hashToken(token), expiresAt); err != nil {
+		return fmt.Errorf("failed to store reset token: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit reset token: %v", err)
+	}
+
+	return SendPasswordResetEmail(user.Email, token)
+}
+
+// ResetPassword consumes a reset token, stores the new password and signs the
+// user out everywhere.
+func ResetPassword(token, newPassword string) ([]ValidationError, error) {
+	if validationErrors := ValidatePassword(newPassword); len(validationErrors) > 0 {
+		return validationErrors, nil
+	}
+
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var reset models.DbPasswordReset
+	err = tx.Get(&reset, models.PasswordResetQueries.GetByHashForUpdate, hashToken(token))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidResetToken
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to look up reset token: %v", err)
+	}
+
+	if reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+		return nil, ErrInvalidResetToken
+	}
+
+	passwordHash, err := hashPassword(newPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(models.UserQueries.UpdatePassword, passwordHash, reset.UserID); err != nil {
+		return nil, fmt.Errorf("failed to update password: %v", err)
+	}
+
+	if _, err := tx.Exec(models.PasswordResetQueries.MarkUsed, reset.ID); err != nil {
+		return nil, fmt.Errorf("failed to consume reset token: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit password reset: %v", err)
+	}
+
+	if err := RevokeAllUserTokens(reset.UserID); err != nil {
+		return nil, err
+	}
+
+	if user, err := GetUserByID(reset.UserID); err == nil {
+		SendPasswordChangedEmail(user.Email, user.Username)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"goserver/internal/database"
+	"goserver/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// PersonalTokenPrefix marks a bearer credential as a personal access token
+// rather than a JWT, and makes leaked tokens easy to search for.
+const PersonalTokenPrefix = "edl_pat_"
+
+const (
+	defaultPersonalTokenDays = 90
+	maxPersonalTokenDays     = 365
+)
+
+var (
+	ErrInvalidPersonalToken  = errors.New("invalid, expired or revoked personal access token")
+	ErrPersonalTokenNotFound = errors.New("personal access token not found")
+)
+
+// CreatePersonalTokenRequest is the body accepted when creating a token
+type CreatePersonalTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// CreatePersonalToken stores a new token for the user and returns it along
+// with the raw value, which is never shown again.
+func CreatePersonalToken(userID int, req *CreatePersonalTokenRequest) (*models.DbPersonalAccessToken, string, []ValidationError, error) {
+	var validationErrors []ValidationError
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		validationErrors = append(validationErrors, ValidationError{Field: "name", Message: "Name is required and must be at most 100 characters"})
+	}
+
+	if len(req.Scopes) == 0 {
+		validationErrors = append(validationErrors, ValidationError{Field: "scopes", Message: "At least one scope is required"})
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(models.TOKEN_SCOPES, scope) {
+			validationErrors = append(validationErrors, ValidationError{Field: "scopes", Message: fmt.Sprintf("Unknown scope %q", scope)})
+		}
+	}
+
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultPersonalTokenDays
+	}
+	if req.ExpiresInDays < 1 || req.ExpiresInDays > maxPersonalTokenDays {
+		validationErrors = append(validationErrors, ValidationError{Field: "expires_in_days", Message: fmt.Sprintf("Expiry must be between 1 and %d days", maxPersonalTokenDays)})
+	}
+
+	if len(validationErrors) > 0 {
+		return nil, "", validationErrors, nil
+	}
+
+	secret, err := generateOpaqueToken()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	rawToken := PersonalTokenPrefix + secret
+
+	token := &models.DbPersonalAccessToken{
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    rawToken[:len(PersonalTokenPrefix)+6],
+		TokenHash: hashToken(rawToken),
+		Scopes:    pq.StringArray(req.Scopes),
+		ExpiresAt: time.Now().AddDate(0, 0, req.ExpiresInDays),
+	}
+	err = database.DB.QueryRowx(models.PersonalTokenQueries.Insert,
+		token.UserID, token.Name, token.Prefix, token.TokenHash, token.Scopes, token.ExpiresAt).
+		Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to create personal access token: %v", err)
+	}
+
+	log.Printf("User %d created personal access token %d with scopes %v", userID, token.ID, req.Scopes)
+	return token, rawToken, nil, nil
+}
+
+// GetPersonalTokens lists a user's tokens, including expired and revoked ones
+func GetPersonalTokens(userID int) ([]models.DbPersonalAccessToken, error) {
+	tokens := []models.DbPersonalAccessToken{}
+	if err := database.DB.Select(&tokens, models.PersonalTokenQueries.GetByUserID, userID); err != nil {
+		return nil, fmt.Errorf("failed to get personal access tokens: %v", err)
+	}
+	return tokens, nil
+}
+
+// RevokePersonalToken revokes one of the user's tokens
+func RevokePersonalToken(userID, tokenID int) error {
+	result, err := database.DB.Exec(models.PersonalTokenQueries.Revoke, tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke personal access token: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrPersonalTokenNotFound
+	}
+	return nil
+}
+
+// AuthenticatePersonalToken resolves a raw token to its owner and scopes
+func AuthenticatePersonalToken(rawToken string) (*models.DbUser, []string, error) {
+	var token models.DbPersonalAccessToken
+	err := database.DB.Get(&token, models.PersonalTokenQueries.GetByHash, hashToken(rawToken))
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrInvalidPersonalToken
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("failed to look up personal access token: %v", err)
+	}
+
+	if token.RevokedAt != nil || time.Now().After(token.ExpiresAt) {
+		return nil, nil, ErrInvalidPersonalToken
+	}
+	// Tokens created before a sign-out-everywhere are void even if the
+	// revoked_at update did not reach them
+	if IsTokenRevoked("", "", token.UserID, token.CreatedAt) {
+		return nil, nil, ErrInvalidPersonalToken
+	}
+
+	user, err := GetUserByID(token.UserID)
+	if err != nil {
+		return nil, nil, ErrInvalidPersonalToken
+	}
+
+	if _, err := database.DB.Exec(models.PersonalTokenQueries.TouchUsed, token.ID); err != nil {
+		log.Printf("Failed to record use of personal access token %d: %v", token.ID, err)
+	}
+
+	return user, token.Scopes, nil
+}
@@ -0,0 +1,327 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+
+	"goserver/internal/database"
+	"goserver/internal/models"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrRoleNotFound  = errors.New("role not found")
+	ErrRoleInUse     = errors.New("role is still assigned to users")
+	ErrRoleProtected = errors.New("the default role cannot be deleted")
+	ErrUnknownRole   = errors.New("unknown role")
+)
+
+// RoleRequest is the body accepted when creating or updating a role
+type RoleRequest struct {
+	Name        string   `json:"name"`
+	Level       int      `json:"level"`
+	RequireMFA  bool     `json:"require_mfa"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// roleCache holds the roles table in memory; permission checks run on every
+// request, role edits are rare.
+var roleCache struct {
+	mu    sync.RWMutex
+	roles map[string]models.DbRole
+}
+
+// defaultRoleName is the role given to new accounts
+func defaultRoleName() string {
+	return models.USER_ROLES["USER"].Name
+}
+
+// SeedRoles inserts the built-in roles and permissions that are missing from
+// the database, then loads the role cache. Existing roles are left as the
+// admins configured them, but a newly introduced permission is granted to
+// the built-in roles that have it by default.
+func SeedRoles() error {
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var added []string
+	for _, perm := range models.PERMISSIONS {
+		var inserted []string
+		if err := tx.Select(&inserted, models.RoleQueries.InsertPermission, perm.Name, perm.Description); err != nil {
+			return fmt.Errorf("failed to seed permission %s: %v", perm.Name, err)
+		}
+		added = append(added, inserted...)
+	}
+
+	for _, role := range models.USER_ROLES {
+		var inserted []int
+		if err := tx.Select(&inserted, models.RoleQueries.Insert, role.Name, role.Level, role.RequireMFA, ""); err != nil {
+			return fmt.Errorf("failed to seed role %s: %v", role.Name, err)
+		}
+		for _, perm := range role.Permissions {
+			if len(inserted) == 0 && !slices.Contains(added, perm) {
+				continue
+			}
+			if _, err := tx.Exec(models.RoleQueries.GrantPermission, role.Name, perm); err != nil {
+				return fmt.Errorf("failed to grant %s to %s: %v", perm, role.Name, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit role seed: %v", err)
+	}
+
+	return loadRoles()
+}
+
+func loadRoles() error {
+	var rows []models.DbRole
+	if err := database.DB.Select(&rows, models.RoleQueries.GetAll); err != nil {
+		return fmt.Errorf("failed to load roles: %v", err)
+	}
+
+	roles := make(map[string]models.DbRole, len(rows))
+	for _, role := range rows {
+		roles[role.Name] = role
+	}
+
+	roleCache.mu.Lock()
+	roleCache.roles = roles
+	roleCache.mu.Unlock()
+
+	log.Printf("Loaded %d roles", len(roles))
+	return nil
+}
+
+// GetRoles returns every role with its permissions, lowest level first
+func GetRoles() []models.DbRole {
+	roleCache.mu.RLock()
+	defer roleCache.mu.RUnlock()
+
+	roles := make([]models.DbRole, 0, len(roleCache.roles))
+	for _, role := range roleCache.roles {
+		roles = append(roles, role)
+	}
+	slices.SortFunc(roles, func(a, b models.DbRole) int {
+		if a.Level != b.Level {
+			return a.Level - b.Level
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return roles
+}
+
+// GetRole looks up a role by name
+func GetRole(name string) (models.DbRole, bool) {
+	roleCache.mu.RLock()
+	defer roleCache.mu.RUnlock()
+
+	role, ok := roleCache.roles[name]
+	return role, ok
+}
+
+// RoleHasPermission reports whether the named role grants a permission
+func RoleHasPermission(roleName, permission string) bool {
+	role, ok := GetRole(roleName)
+	return ok && slices.Contains(role.Permissions, permission)
+}
+
+// adminPermissions let a role administer other accounts. Roles holding any
+// of them are handed out only by invitation or by an administrator, never
+// through a role request or an email match at sign-in.
+var adminPermissions = []string{models.PermUsersManage, models.PermRolesManage, models.PermUsersImpersonate}
+
+// isAdminRole reports whether the named role holds any admin permission
+func isAdminRole(roleName string) bool {
+	for _, permission := range adminPermissions {
+		if RoleHasPermission(roleName, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// canGrantRole reports whether granter may hand out the named role: it must
+// sit no higher than granter's own role and grant nothing granter lacks
+func canGrantRole(granter *models.DbUser, roleName string) bool {
+	own, ok := GetRole(granter.Role)
+	if !ok {
+		return false
+	}
+	role, ok := GetRole(roleName)
+	if !ok || role.Level > own.Level {
+		return false
+	}
+	for _, permission := range role.Permissions {
+		if !slices.Contains(own.Permissions, permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// RoleRequiresMFA reports whether accounts with the named role must enroll in
+// two-factor authentication before they can sign in.
+func RoleRequiresMFA(roleName string) bool {
+	role, ok := GetRole(roleName)
+	return ok && role.RequireMFA
+}
+
+// usersWithPermission lists the users whose role grants a permission
+func usersWithPermission(permission string) ([]models.DbUser, error) {
+	var roles []string
+	for _, role := range GetRoles() {
+		if slices.Contains(role.Permissions, permission) {
+			roles = append(roles, role.Name)
+		}
+	}
+
+	users := []models.DbUser{}
+	if err := database.DB.Select(&users, models.UserQueries.GetByRoles, pq.Array(roles)); err != nil {
+		return nil, fmt.Errorf("failed to get users with %s: %v", permission, err)
+	}
+	return users, nil
+}
+
+// GetPermissions lists every permission a role can be granted
+func GetPermissions() ([]models.DbPermission, error) {
+	permissions := []models.DbPermission{}
+	if err := database.DB.Select(&permissions, models.RoleQueries.GetPermissions); err != nil {
+		return nil, fmt.Errorf("failed to get permissions: %v", err)
+	}
+	return permissions, nil
+}
+
+func validateRoleRequest(req *RoleRequest) []ValidationError {
+	var validationErrors []ValidationError
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 50 {
+		validationErrors = append(validationErrors, ValidationError{Field: "name", Message: "Name is required and must be at most 50 characters"})
+	}
+	if req.Level < 1 {
+		validationErrors = append(validationErrors, ValidationError{Field: "level", Message: "Level must be at least 1"})
+	}
+	for _, perm := range req.Permissions {
+		if !slices.ContainsFunc(models.PERMISSIONS, func(p models.DbPermission) bool { return p.Name == perm }) {
+			validationErrors = append(validationErrors, ValidationError{Field: "permissions", Message: fmt.Sprintf("Unknown permission %q", perm)})
+		}
+	}
+	return validationErrors
+}
+
+// CreateRole adds a role with the given permissions
+func CreateRole(req *RoleRequest) (*models.DbRole, []ValidationError, error) {
+	if validationErrors := validateRoleRequest(req); len(validationErrors) > 0 {
+		return nil, validationErrors, nil
+	}
+	if _, exists := GetRole(req.Name); exists {
+		return nil, []ValidationError{{Field: "name", Message: "A role with this name already exists"}}, nil
+	}
+
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var inserted []int
+	if err := tx.Select(&inserted, models.RoleQueries.Insert, req.Name, req.Level, req.RequireMFA, req.Description); err != nil {
+		return nil, nil, fmt.Errorf("failed to create role: %v", err)
+	}
+	if len(inserted) == 0 {
+		return nil, []ValidationError{{Field: "name", Message: "A role with this name already exists"}}, nil
+	}
+
+	for _, perm := range req.Permissions {
+		if _, err := tx.Exec(models.RoleQueries.GrantPermission, req.Name, perm); err != nil {
+			return nil, nil, fmt.Errorf("failed to grant %s: %v", perm, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit role: %v", err)
+	}
+	if err := loadRoles(); err != nil {
+		return nil, nil, err
+	}
+
+	role, _ := GetRole(req.Name)
+	return &role, nil, nil
+}
+
+// UpdateRole replaces a role's level, MFA requirement, description and
+// permissions. Permissions are resolved per request, so signed-in users pick
+// up the change immediately.
+func UpdateRole(name string, req *RoleRequest) (*models.DbRole, []ValidationError, error) {
+	req.Name = name
+	if validationErrors := validateRoleRequest(req); len(validationErrors) > 0 {
+		return nil, validationErrors, nil
+	}
+
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var updated []int
+	if err := tx.Select(&updated, models.RoleQueries.Update, req.Level, req.RequireMFA, req.Description, name); err != nil {
+		return nil, nil, fmt.Errorf("failed to update role: %v", err)
+	}
+	if len(updated) == 0 {
+		return nil, nil, ErrRoleNotFound
+	}
+
+	if _, err := tx.Exec(models.RoleQueries.ClearPermissions, updated[0]); err != nil {
+		return nil, nil, fmt.Errorf("failed to clear permissions: %v", err)
+	}
+	for _, perm := range req.Permissions {
+		if _, err := tx.Exec(models.RoleQueries.GrantPermission, name, perm); err != nil {
+			return nil, nil, fmt.Errorf("failed to grant %s: %v", perm, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit role: %v", err)
+	}
+	if err := loadRoles(); err != nil {
+		return nil, nil, err
+	}
+
+	role, _ := GetRole(name)
+	return &role, nil, nil
+}
+
+// DeleteRole removes a role that no user holds
+func DeleteRole(name string) error {
+	if name == defaultRoleName() {
+		return ErrRoleProtected
+	}
+	if _, ok := GetRole(name); !ok {
+		return ErrRoleNotFound
+	}
+
+	var count int
+	if err := database.DB.Get(&count, models.RoleQueries.CountUsers, name); err != nil {
+		return fmt.Errorf("failed to count role users: %v", err)
+	}
+	if count > 0 {
+		return ErrRoleInUse
+	}
+
+	if _, err := database.DB.Exec(models.RoleQueries.Delete, name); err != nil {
+		return fmt.Errorf("failed to delete role: %v", err)
+	}
+	return loadRoles()
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"goserver/internal/config"
+	"goserver/internal/database"
+	"goserver/internal/models"
+)
+
+// revocationCache mirrors the revoked_tokens and user_token_revocations tables,
+// and recently revoked sessions, so RequireAuth never has to hit the database
+// on the request path.
+type revocationCache struct {
+	mu       sync.RWMutex
+	tokens   map[string]time.Time // jti -> token expiry
+	users    map[int]time.Time    // user id -> tokens issued before this are revoked
+	sessions map[string]time.Time // session id -> when it was revoked
+}
+
+var revocations = &revocationCache{
+	tokens:   map[string]time.Time{},
+	users:    map[int]time.Time{},
+	sessions: map[string]time.Time{},
+}
+
+// LoadRevocations fills the in-memory cache from Postgres. Call once at startup.
+func LoadRevocations() error {
+	if _, err := database.DB.Exec(models.RevocationQueries.PurgeExpired); err != nil {
+		return fmt.Errorf("failed to purge expired revocations: %v", err)
+	}
+
+	var tokens []models.DbRevokedToken
+	if err := database.DB.Select(&tokens, models.RevocationQueries.GetActiveTokens); err != nil {
+		return fmt.Errorf("failed to load revoked tokens: %v", err)
+	}
+
+	var users []models.DbUserTokenRevocation
+	if err := database.DB.Select(&users, models.RevocationQueries.GetUsers); err != nil {
+		return fmt.Errorf("failed to load user revocations: %v", err)
+	}
+
+	// Access tokens from sessions revoked longer ago than their TTL have expired anyway
+	var sessions []models.DbUserSession
+	since := time.Now().Add(-config.Load().AccessTokenTTL)
+	if err := database.DB.Select(&sessions, models.SessionQueries.GetRevokedSince, since); err != nil {
+		return fmt.Errorf("failed to load revoked sessions: %v", err)
+	}
+
+	revocations.mu.Lock()
+	defer revocations.mu.Unlock()
+	for _, t := range tokens {
+		revocations.tokens[t.JTI] = t.ExpiresAt
+	}
+	for _, u := range users {
+		revocations.users[u.UserID] = u.RevokedBefore
+	}
+	for _, sess := range sessions {
+		revocations.sessions[sess.ID] = *sess.RevokedAt
+	}
+
+	log.Printf("Loaded %d revoked tokens, %d user revocations and %d revoked sessions", len(tokens), len(users), len(sessions))
+	return nil
+}
+
+// RevokeToken revokes a single access token until it would have expired anyway.
+func RevokeToken(jti string, userID int, expiresAt time.Time) error {
+	if jti == "" {
+		return nil
+	}
+
+	if _, err := database.DB.Exec(models.RevocationQueries.InsertToken, jti, userID, expiresAt); err != nil {
+		return fmt.Errorf("failed to revoke token: %v", err)
+	}
+
+	revocations.mu.Lock()
+	revocations.tokens[jti] = expiresAt
+	revocations.mu.Unlock()
+	return nil
+}
+
+// RevokeAllUserTokens invalidates every access, refresh and personal access
+// token issued to the user so far. Tokens issued afterwards are unaffected.
+func RevokeAllUserTokens(userID int) error {
+	now := time.Now()
+	if _, err := database.DB.Exec(models.RevocationQueries.UpsertUser, userID, now); err != nil {
+		return fmt.Errorf("failed to revoke user tokens: %v", err)
+	}
+
+	revocations.mu.Lock()
+	revocations.users[userID] = now
+	revocations.mu.Unlock()
+
+	if _, err := database.DB.Exec(models.PersonalTokenQueries.RevokeAll, userID); err != nil {
+		return fmt.Errorf("failed to revoke personal access tokens: %v", err)
+	}
+
+	log.Printf("Revoked all tokens for user %d", userID)
+	return RevokeUserRefreshTokens(userID)
+}
+
+// markSessionsRevoked makes access tokens from the given sessions fail at
+// once rather than when they expire. Call after the revocation is committed.
+func markSessionsRevoked(sessionIDs ...string) {
+	if len(sessionIDs) == 0 {
+		return
+	}
+
+	revocations.mu.Lock()
+	defer revocations.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-config.Load().AccessTokenTTL)
+	for id, revokedAt := range revocations.sessions {
+		if revokedAt.Before(cutoff) {
+			delete(revocations.sessions, id)
+		}
+	}
+	for _, id := range sessionIDs {
+		revocations.sessions[id] = now
+	}
+}
+
+// IsTokenRevoked reports whether an access token has been revoked, either
+// individually, with its session, or by a user-wide revocation issued after it.
+func IsTokenRevoked(jti, sessionID string, userID int, issuedAt time.Time) bool {
+	revocations.mu.RLock()
+	defer revocations.mu.RUnlock()
+
+	if expiresAt, ok := revocations.tokens[jti]; ok && time.Now().Before(expiresAt) {
+		return true
+	}
+
+	if _, ok := revocations.sessions[sessionID]; ok && sessionID != "" {
+		return true
+	}
+
+	if cutoff, ok := revocations.users[userID]; ok && issuedAt.Before(cutoff) {
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"goserver/internal/database"
+	"goserver/internal/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrRoleRequestPending  = errors.New("you already have a pending role request")
+	ErrRoleRequestNotFound = errors.New("role request not found")
+	ErrRoleRequestReviewed = errors.New("role request has already been reviewed")
+	ErrRoleRequestOwn      = errors.New("you cannot review your own role request")
+	ErrRoleAboveReviewer   = errors.New("you cannot grant a role with more access than your own")
+)
+
+// CreateRoleRequest records a user's request for a higher role and lets the
+// admins know. Roles that can administer accounts are not requestable.
+func CreateRoleRequest(user *models.DbUser, requestedRole, note string) (*models.DbRoleRequest, []ValidationError, error) {
+	note = strings.TrimSpace(note)
+	if len(note) > 1000 {
+		return nil, []ValidationError{{Field: "note", Message: "Note must be at most 1000 characters"}}, nil
+	}
+
+	role, ok := GetRole(requestedRole)
+	if !ok {
+		return nil, []ValidationError{{Field: "role", Message: "Unknown role"}}, nil
+	}
+	current, _ := GetRole(user.Role)
+	if role.Level <= current.Level {
+		return nil, []ValidationError{{Field: "role", Message: "You can only request a role above your current one"}}, nil
+	}
+	if isAdminRole(role.Name) {
+		return nil, []ValidationError{{Field: "role", Message: "Administrative roles can't be requested"}}, nil
+	}
+
+	request := &models.DbRoleRequest{
+		UserID:        user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		CurrentRole:   user.Role,
+		RequestedRole: role.Name,
+		Note:          note,
+	}
+	err := database.DB.QueryRowx(models.RoleRequestQueries.Insert, user.ID, role.Name, note).
+		Scan(&request.ID, &request.Status, &request.CreatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, nil, ErrRoleRequestPending
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("failed to create role request: %v", err)
+	}
+
+	notifyRoleRequestReviewers(request)
+	return request, nil, nil
+}
+
+// notifyRoleRequestReviewers emails everyone whose role can manage users
+func notifyRoleRequestReviewers(request *models.DbRoleRequest) {
+	reviewers, err := usersWithPermission(models.PermUsersManage)
+	if err != nil {
+		log.Printf("Failed to look up role request reviewers: %v", err)
+		return
+	}
+	for _, reviewer := range reviewers {
+		SendRoleRequestEmail(reviewer.Email, request.Username, request.RequestedRole, request.Note)
+	}
+}
+
+// GetUserRoleRequests lists a user's own requests, newest first
+func GetUserRoleRequests(userID int) ([]models.DbRoleRequest, error) {
+	requests := []models.DbRoleRequest{}
+	if err := database.DB.Select(&requests, models.RoleRequestQueries.GetByUserID, userID); err != nil {
+		return nil, fmt.Errorf("failed to get role requests: %v", err)
+	}
+	return requests, nil
+}
+
+// GetRoleRequests lists requests with the given status (all when empty),
+// oldest first so the queue is worked in order
+func GetRoleRequests(status string) ([]models.DbRoleRequest, error) {
+	requests := []models.DbRoleRequest{}
+	if err := database.DB.Select(&requests, models.RoleRequestQueries.GetByStatus, status); err != nil {
+		return nil, fmt.Errorf("failed to get role requests: %v", err)
+	}
+	return requests, nil
+}
+
+// ReviewRoleRequest approves or denies a pending request. Approval moves the
+// user to the requested role and signs them out so new tokens carry it. A
+// request the user has since outgrown is closed as denied rather than applied.
+// Reviewers can't decide their own requests or grant more than they hold.
+func ReviewRoleRequest(requestID int, reviewer *models.DbUser, approve bool, reviewNote string) (*models.DbRoleRequest, error) {
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var request models.DbRoleRequest
+	err = tx.Get(&request, models.RoleRequestQueries.GetByIDForUpdate, requestID)
+	if err == sql.ErrNoRows {
+		return nil, ErrRoleRequestNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get role request: %v", err)
+	}
+	if request.Status != models.RoleRequestPending {
+		return nil, ErrRoleRequestReviewed
+	}
+	if request.UserID == reviewer.ID {
+		return nil, ErrRoleRequestOwn
+	}
+
+	reviewNote = strings.TrimSpace(reviewNote)
+	request.Status = models.RoleRequestDenied
+	if approve {
+		requested, ok := GetRole(request.RequestedRole)
+		if !ok {
+			return nil, ErrUnknownRole
+		}
+		// The user's role may have changed since they asked. Approving a
+		// request that is no longer a promotion would demote them, so it is
+		// closed instead.
+		if current, _ := GetRole(request.CurrentRole); requested.Level <= current.Level {
+			return closeStaleRoleRequest(tx, &request, reviewer)
+		}
+		if !canGrantRole(reviewer, requested.Name) {
+			return nil, ErrRoleAboveReviewer
+		}
+		request.Status = models.RoleRequestApproved
+		if _, err := tx.Exec(models.UserQueries.UpdateRole, request.RequestedRole, request.UserID); err != nil {
+			return nil, fmt.Errorf("failed to update user role: %v", err)
+		}
+	}
+
+	if _, err := tx.Exec(models.RoleRequestQueries.Review, request.Status, reviewer.ID, reviewNote, request.ID); err != nil {
+		return nil, fmt.Errorf("failed to update role request: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit role request review: %v", err)
+	}
+	request.ReviewerID = &reviewer.ID
+	request.ReviewNote = reviewNote
+
+	if approve {
+		if err := RevokeAllUserTokens(request.UserID); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Role request %d %s by user %d", request.ID, request.Status, reviewer.ID)
+	SendRoleRequestOutcomeEmail(request.Email, request.Username, request.RequestedRole, approve, reviewNote)
+	return &request, nil
+}
+
+// closeStaleRoleRequest denies a request whose user already has the requested
+// role or a higher one. The user is not emailed since nothing was refused.
+func closeStaleRoleRequest(tx *sqlx.Tx, request *models.DbRoleRequest, reviewer *models.DbUser) (*models.DbRoleRequest, error) {
+	request.Status = models.RoleRequestDenied
+	request.ReviewNote = fmt.Sprintf("No longer applicable: user already has the %s role", request.CurrentRole)
+	if _, err := tx.Exec(models.RoleRequestQueries.Review, request.Status, reviewer.ID, request.ReviewNote, request.ID); err != nil {
+		return nil, fmt.Errorf("failed to update role request: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit role request review: %v", err)
+	}
+	request.ReviewerID = &reviewer.ID
+
+	log.Printf("Role request %d closed by user %d: user already has role %s", request.ID, reviewer.ID, request.CurrentRole)
+	return request, nil
+}
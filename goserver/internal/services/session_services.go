@@ -0,0 +1,72 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"goserver/internal/database"
+	"goserver/internal/models"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// GetSessions lists the user's signed-in devices, most recently used first
+func GetSessions(userID int) ([]models.DbUserSession, error) {
+	sessions := []models.DbUserSession{}
+	if err := database.DB.Select(&sessions, models.SessionQueries.GetActiveByUser, userID); err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %v", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession signs one of the user's devices out. Its refresh token stops
+// working and its access tokens are rejected immediately.
+func RevokeSession(userID int, sessionID string) error {
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var revoked []string
+	if err := tx.Select(&revoked, models.SessionQueries.Revoke, sessionID, userID); err != nil {
+		return fmt.Errorf("failed to revoke session: %v", err)
+	}
+	if len(revoked) == 0 {
+		return ErrSessionNotFound
+	}
+
+	if _, err := tx.Exec(models.RefreshTokenQueries.RevokeFamily, sessionID); err != nil {
+		return fmt.Errorf("failed to revoke token family: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit session revocation: %v", err)
+	}
+	markSessionsRevoked(revoked...)
+	return nil
+}
+
+// RevokeOtherSessions signs the user out everywhere except the given session
+func RevokeOtherSessions(userID int, currentSessionID string) (int, error) {
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var revoked []string
+	if err := tx.Select(&revoked, models.SessionQueries.RevokeOthers, userID, currentSessionID); err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %v", err)
+	}
+
+	if _, err := tx.Exec(models.RefreshTokenQueries.RevokeOthers, userID, currentSessionID); err != nil {
+		return 0, fmt.Errorf("failed to revoke refresh tokens: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit session revocation: %v", err)
+	}
+	markSessionsRevoked(revoked...)
+	return len(revoked), nil
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// Cooldown allows an action once per window for each key, e.g. an email
+// address or client IP. State is kept in memory only.
+type Cooldown struct {
+	mu     sync.Mutex
+	window time.Duration
+	last   map[string]time.Time
+}
+
+func NewCooldown(window time.Duration) *Cooldown {
+	return &Cooldown{window: window, last: map[string]time.Time{}}
+}
+
+// Allow records an attempt for key. When the key is still cooling down it
+// returns false and how long the caller must wait.
+func (cd *Cooldown) Allow(key string) (bool, time.Duration) {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+
+	now := time.Now()
+	if last, ok := cd.last[key]; ok {
+		if wait := cd.window - now.Sub(last); wait > 0 {
+			return false, wait
+		}
+	}
+
+	cd.last[key] = now
+	if len(cd.last) > 1000 {
+		cd.prune(now)
+	}
+	return true, 0
+}
+
+func (cd *Cooldown) prune(now time.Time) {
+	for key, last := range cd.last {
+		if now.Sub(last) >= cd.window {
+			delete(cd.last, key)
+		}
+	}
+}
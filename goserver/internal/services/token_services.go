@@ -0,0 +1,238 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"goserver/internal/config"
+	"goserver/internal/database"
+	"goserver/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+)
+
+// TokenPair is returned to clients after a successful sign-in or refresh.
+type TokenPair struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int64  `json:"expiresIn"`
+}
+
+// ClientInfo describes the device a refresh token was issued to.
+type ClientInfo struct {
+	DeviceID  string
+	UserAgent string
+	IPAddress string
+}
+
+// generateOpaqueToken returns a URL-safe random token with 256 bits of entropy.
+func generateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hex SHA-256 of an opaque token. Only hashes are stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueTokenPair starts a new refresh token family for the user. Any family
+// previously issued to the same device is revoked.
+func IssueTokenPair(user *models.DbUser, client ClientInfo) (*TokenPair, error) {
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(models.RefreshTokenQueries.RevokeDevice, user.ID, client.DeviceID); err != nil {
+		return nil, fmt.Errorf("failed to revoke device tokens: %v", err)
+	}
+	var replaced []string
+	if err := tx.Select(&replaced, models.SessionQueries.RevokeDevice, user.ID, client.DeviceID); err != nil {
+		return nil, fmt.Errorf("failed to revoke device sessions: %v", err)
+	}
+
+	familyID := uuid.New().String()
+	refreshToken, expiresAt, err := insertRefreshToken(tx, user.ID, familyID, client)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(models.SessionQueries.Insert, familyID, user.ID, client.DeviceID, client.UserAgent, client.IPAddress, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store session: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit refresh token: %v", err)
+	}
+	markSessionsRevoked(replaced...)
+
+	return buildTokenPair(user, familyID, refreshToken)
+}
+
+// RotateRefreshToken exchanges a refresh token for a new pair. Presenting a
+// token that was already rotated or revoked revokes its whole family.
+func RotateRefreshToken(rawToken string, client ClientInfo) (*TokenPair, error) {
+	if rawToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var current models.DbRefreshToken
+	err = tx.Get(&current, models.RefreshTokenQueries.GetByHashForUpdate, hashToken(rawToken))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidRefreshToken
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to look up refresh token: %v", err)
+	}
+
+	if current.RotatedAt != nil || current.RevokedAt != nil {
+		if _, err := tx.Exec(models.RefreshTokenQueries.RevokeFamily, current.FamilyID); err != nil {
+			return nil, fmt.Errorf("failed to revoke token family: %v", err)
+		}
+		if _, err := tx.Exec(models.SessionQueries.Revoke, current.FamilyID, current.UserID); err != nil {
+			return nil, fmt.Errorf("failed to revoke session: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("failed to commit token revocation: %v", err)
+		}
+		markSessionsRevoked(current.FamilyID)
+		log.Printf("Refresh token reuse detected for user %d, family %s revoked", current.UserID, current.FamilyID)
+		return nil, ErrRefreshTokenReused
+	}
+
+	if time.Now().After(current.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	user, err := GetUserByID(current.UserID)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if _, err := tx.Exec(models.RefreshTokenQueries.MarkRotated, current.ID); err != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %v", err)
+	}
+
+	if client.DeviceID == "" {
+		client.DeviceID = current.DeviceID
+	}
+	refreshToken, expiresAt, err := insertRefreshToken(tx, user.ID, current.FamilyID, client)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(models.SessionQueries.Touch, client.UserAgent, client.IPAddress, expiresAt, current.FamilyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update session: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit refresh token: %v", err)
+	}
+
+	return buildTokenPair(user, current.FamilyID, refreshToken)
+}
+
+// RevokeUserRefreshTokens revokes every outstanding refresh token and
+// session for a user.
+func RevokeUserRefreshTokens(userID int) error {
+	if _, err := database.DB.Exec(models.RefreshTokenQueries.RevokeAllForUser, userID); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %v", err)
+	}
+	if _, err := database.DB.Exec(models.SessionQueries.RevokeAllForUser, userID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %v", err)
+	}
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes the family the given refresh token belongs
+// to. When userID is not zero the token must have been issued to that user.
+func RevokeRefreshTokenFamily(rawToken string, userID int) error {
+	if rawToken == "" {
+		return nil
+	}
+	if userID != 0 {
+		var owner int
+		err := database.DB.Get(&owner, models.RefreshTokenQueries.GetOwner, hashToken(rawToken))
+		if err == sql.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to look up refresh token: %v", err)
+		}
+		if owner != userID {
+			return ErrInvalidRefreshToken
+		}
+	}
+
+	var revoked []string
+	if err := database.DB.Select(&revoked, models.SessionQueries.RevokeByRefreshToken, hashToken(rawToken)); err != nil {
+		return fmt.Errorf("failed to revoke session: %v", err)
+	}
+	if _, err := database.DB.Exec(models.RefreshTokenQueries.RevokeFamilyOf, hashToken(rawToken)); err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %v", err)
+	}
+	markSessionsRevoked(revoked...)
+	return nil
+}
+
+func insertRefreshToken(tx *sqlx.Tx, userID int, familyID string, client ClientInfo) (string, time.Time, error) {
+	refreshToken, err := generateOpaqueToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	var row models.DbRefreshToken
+	expiresAt := time.Now().Add(config.Load().RefreshTokenTTL)
+	err = tx.QueryRowx(models.RefreshTokenQueries.Insert,
+		userID,
+		familyID,
+		hashToken(refreshToken),
+		client.DeviceID,
+		client.UserAgent,
+		client.IPAddress,
+		expiresAt,
+	).Scan(&row.ID, &row.CreatedAt)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to store refresh token: %v", err)
+	}
+
+	return refreshToken, expiresAt, nil
+}
+
+func buildTokenPair(user *models.DbUser, sessionID, refreshToken string) (*TokenPair, error) {
+	accessToken, err := GenerateAccessToken(user, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(config.Load().AccessTokenTTL.Seconds()),
+	}, nil
+}
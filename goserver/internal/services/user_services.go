@@ -6,10 +6,11 @@ import (
 	"fmt"
 	"goserver/internal/database"
 	"goserver/internal/models"
+	"log"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // GetAllUsers retrieves all users from PostgreSQL
@@ -45,14 +46,25 @@ func GetUserByUsername(username string) (*models.DbUser, error) {
 	return &user, nil
 }
 
+// GetUserByEmail retrieves a user by email address, ignoring case
+func GetUserByEmail(email string) (*models.DbUser, error) {
+	var user models.DbUser
+	err := database.DB.Get(&user, models.UserQueries.GetByEmail, email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %v", err)
+	}
+
+	return &user, nil
+}
+
 // CreateUser creates a new user in PostgreSQL
 func CreateUser(user *models.DbUser) error {
 	var existingUser models.DbUser
 	err := database.DB.Get(&existingUser, models.UserQueries.CheckExists, user.Username, user.Email)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("user already exists: %v", err)
-	} else if err == nil {
-		return fmt.Errorf("error checking for existing user")
+	if err == nil {
+		return fmt.Errorf("user already exists")
+	} else if err != sql.ErrNoRows {
+		return fmt.Errorf("error checking for existing user: %v", err)
 	}
 
 	user.VerifyCode = uuid.New().String()
@@ -62,11 +74,10 @@ func CreateUser(user *models.DbUser) error {
 		user.Role = models.USER_ROLES["USER"].Name
 	}
 
-	salt, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	user.Password, err = hashPassword(user.Password)
 	if err != nil {
-		return fmt.Errorf("error hashing password: %v", err)
+		return err
 	}
-	user.Password = string(salt)
 	user.VerifyExpires = time.Now().Add(24 * time.Hour)
 	err = database.DB.QueryRowx(models.UserQueries.Insert, user.Username, user.Password, user.Email, user.Role, user.VerifyCode, user.VerifyExpires).
 		Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
@@ -79,30 +90,68 @@ func CreateUser(user *models.DbUser) error {
 	return nil
 }
 
-// UpdateUser updates an existing user in PostgreSQL
-func UpdateUser(id int, user *models.DbUser) error {
-	err := database.DB.QueryRowx(models.UserQueries.Update, user.Username, user.Email, user.Role, id).
+// UpdateUser updates an existing user in PostgreSQL. Changing the role
+// revokes the user's existing tokens so the new role takes effect at once.
+// A new email address is not stored directly: it starts the same verified
+// change a user makes through /users/me/email. The caller can only move users
+// between roles that carry no more access than their own.
+func UpdateUser(id int, user *models.DbUser, caller *models.DbUser) error {
+	existing, err := GetUserByID(id)
+	if err != nil {
+		return fmt.Errorf("user not found")
+	}
+
+	if _, ok := GetRole(user.Role); !ok {
+		return ErrUnknownRole
+	}
+	// Moving someone into or out of a role takes the access it carries
+	if user.Role != existing.Role && (!canGrantRole(caller, user.Role) || !canGrantRole(caller, existing.Role)) {
+		return ErrRoleNotGrantable
+	}
+
+	if user.Email != "" && !strings.EqualFold(user.Email, existing.Email) {
+		validationErrors, err := StartEmailChange(existing, user.Email)
+		if len(validationErrors) > 0 {
+			return ErrEmailUnavailable
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = database.DB.QueryRowx(models.UserQueries.Update, user.Username, existing.Email, user.Role, id).
 		Scan(&user.UpdatedAt)
 
 	if err != nil {
 		return fmt.Errorf("failed to update user: %v", err)
 	}
 
+	if existing.Role != user.Role {
+		if err := RevokeAllUserTokens(id); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// UpdateUserPassword updates a user's password
+// UpdateUserPassword updates a user's password and signs out every session
 func UpdateUserPassword(id int, passwordHash string) error {
 	_, err := database.DB.Exec(models.UserQueries.UpdatePassword, passwordHash, id)
 	if err != nil {
 		return fmt.Errorf("failed to update password: %v", err)
 	}
 
-	return nil
+	return RevokeAllUserTokens(id)
 }
 
 // DeleteUser deletes a user from PostgreSQL
 func DeleteUser(id int) error {
+	// Revoke first so outstanding access tokens stop working immediately
+	if err := RevokeAllUserTokens(id); err != nil {
+		return err
+	}
+
 	result, err := database.DB.Exec(models.UserQueries.Delete, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete user: %v", err)
@@ -120,6 +169,138 @@ func DeleteUser(id int) error {
 	return nil
 }
 
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrWrongPassword = errors.New("current password is incorrect")
+	ErrLastAdmin     = errors.New("the last account that can manage users cannot be deleted")
+
+	ErrRoleNotGrantable = errors.New("you cannot assign or remove a role with more access than your own")
+)
+
+// UpdateProfileRequest holds the profile fields a user may change themselves.
+// Email changes go through StartEmailChange so the new address is verified.
+type UpdateProfileRequest struct {
+	Username *string `json:"user_name"`
+}
+
+// UpdateProfile applies a user's own profile changes. Blog ownership is keyed
+// by username, so a rename carries the user's posts with it.
+func UpdateProfile(user *models.DbUser, req *UpdateProfileRequest) (*models.DbUser, []ValidationError, error) {
+	if req.Username == nil || *req.Username == user.Username {
+		return user, nil, nil
+	}
+
+	username := strings.TrimSpace(*req.Username)
+	if !usernamePattern.MatchString(username) {
+		return nil, []ValidationError{{Field: "user_name", Message: "Username must be 3-32 characters of letters, digits, '.', '_' or '-'"}}, nil
+	}
+	if existing, err := GetUserByUsername(username); err == nil && existing.ID != user.ID {
+		return nil, []ValidationError{{Field: "user_name", Message: "This username is already taken"}}, nil
+	}
+
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	updated := *user
+	if err := tx.QueryRowx(models.UserQueries.UpdateUsername, username, user.ID).Scan(&updated.UpdatedAt); err != nil {
+		return nil, nil, fmt.Errorf("failed to update username: %v", err)
+	}
+	if _, err := tx.Exec(models.BlogQueries.RenameOwner, username, user.Username); err != nil {
+		return nil, nil, fmt.Errorf("failed to rename blog owner: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit profile update: %v", err)
+	}
+
+	log.Printf("User %d renamed from %s to %s", user.ID, user.Username, username)
+	updated.Username = username
+	return &updated, nil, nil
+}
+
+// checkOwnPassword reports whether password is the user's current password.
+// Wrong guesses count towards the same lockout as failed logins, so a stolen
+// session can't be used to brute-force the password.
+func checkOwnPassword(user *models.DbUser, password, ip string) (bool, error) {
+	wait, err := CheckLoginThrottle(user.Username, ip)
+	if err != nil {
+		return false, err
+	}
+	if wait > 0 {
+		RecordLoginAttempt(user.Username, ip, false, LoginThrottled)
+		return false, &LoginThrottledError{RetryAfter: wait}
+	}
+
+	found, err := GetUser(user.Username, password)
+	if err != nil {
+		return false, err
+	}
+	if found == nil || found.ID != user.ID {
+		RecordLoginAttempt(user.Username, ip, false, LoginInvalidCredentials)
+		return false, nil
+	}
+	return true, nil
+}
+
+// ChangePassword replaces the user's password after checking the current
+// one, and signs out every session.
+func ChangePassword(user *models.DbUser, currentPassword, newPassword, ip string) ([]ValidationError, error) {
+	ok, err := checkOwnPassword(user, currentPassword, ip)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrWrongPassword
+	}
+
+	if validationErrors := ValidatePassword(newPassword); len(validationErrors) > 0 {
+		return validationErrors, nil
+	}
+	if currentPassword == newPassword {
+		return []ValidationError{{Field: "new_password", Message: "New password must be different from the current one"}}, nil
+	}
+
+	passwordHash, err := hashPassword(newPassword)
+	if err != nil {
+		return nil, err
+	}
+	if err := UpdateUserPassword(user.ID, passwordHash); err != nil {
+		return nil, err
+	}
+
+	log.Printf("User %d changed their password", user.ID)
+	SendPasswordChangedEmail(user.Email, user.Username)
+	return nil, nil
+}
+
+// DeleteOwnAccount deletes the user's account after checking their password.
+// The last account able to manage users is kept so the site stays administrable.
+func DeleteOwnAccount(user *models.DbUser, password, ip string) error {
+	ok, err := checkOwnPassword(user, password, ip)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrWrongPassword
+	}
+
+	if RoleHasPermission(user.Role, models.PermUsersManage) {
+		managers, err := usersWithPermission(models.PermUsersManage)
+		if err != nil {
+			return err
+		}
+		if len(managers) <= 1 {
+			return ErrLastAdmin
+		}
+	}
+
+	log.Printf("User %d deleted their account", user.ID)
+	return DeleteUser(user.ID)
+}
+
 // AuthenticateUser checks user credentials
 func AuthenticateUser(username string) (*models.DbUser, error) {
 	var user models.DbUser
@@ -156,3 +337,33 @@ func VerifyUserEmail(code string) (*models.DbUser, error) {
 	SendWelcomeEmail(user.Email, user.Username) // Changed from user.Name to user.Username
 	return &user, nil
 }
+
+var (
+	resendEmailCooldown = NewCooldown(5 * time.Minute)
+	resendIPCooldown    = NewCooldown(time.Minute)
+)
+
+// ResendVerificationEmail issues a fresh verification code to an unapproved
+// account. Unknown and already-verified addresses are silently ignored. When
+// the email or IP is cooling down, the remaining wait is returned instead.
+func ResendVerificationEmail(email, ip string) (time.Duration, error) {
+	if ok, wait := resendIPCooldown.Allow(ip); !ok {
+		return wait, nil
+	}
+	if ok, wait := resendEmailCooldown.Allow(strings.ToLower(email)); !ok {
+		return wait, nil
+	}
+
+	user, err := GetUserByEmail(email)
+	if err != nil || user.Approved {
+		return 0, nil
+	}
+
+	code := uuid.New().String()
+	expires := time.Now().Add(24 * time.Hour)
+	if _, err := database.DB.Exec(models.UserQueries.ResetVerifyCode, code, expires, user.ID); err != nil {
+		return 0, fmt.Errorf("failed to reset verification code: %v", err)
+	}
+
+	return 0, SendVerificationEmail(user.Email, user.Username, code)
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"goserver/internal/config"
+	"goserver/internal/database"
+	"goserver/internal/models"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+var (
+	ErrPasskeyUnavailable    = errors.New("no passkeys are registered for this account, please sign in with your password")
+	ErrInvalidPasskeySession = errors.New("passkey ceremony expired, please try again")
+	ErrPasskeyFailed         = errors.New("passkey verification failed")
+	ErrPasskeyNotFound       = errors.New("passkey not found")
+)
+
+var (
+	webAuthnOnce     sync.Once
+	webAuthnInstance *webauthn.WebAuthn
+	webAuthnErr      error
+
+	passkeySessions = newCeremonyStore[webauthn.SessionData](5 * time.Minute)
+)
+
+// passkeyLoginOptions demand user verification (a PIN or biometric on the
+// authenticator) on every passkey sign-in. The assertion then proves both
+// possession and the user, so it stands in for password plus second factor
+// and passkey logins skip the MFA step even for roles that require MFA. A
+// security key that only proves presence is refused.
+var passkeyLoginOptions = []webauthn.LoginOption{
+	webauthn.WithUserVerification(protocol.VerificationRequired),
+}
+
+// passkeyUser adapts a DbUser and its stored credentials to webauthn.User
+type passkeyUser struct {
+	user        *models.DbUser
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte                         { return []byte(strconv.Itoa(u.user.ID)) }
+func (u *passkeyUser) WebAuthnName() string                       { return u.user.Username }
+func (u *passkeyUser) WebAuthnDisplayName() string                { return u.user.Username }
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+func getWebAuthn() (*webauthn.WebAuthn, error) {
+	webAuthnOnce.Do(func() {
+		cfg := config.Load()
+		webAuthnInstance, webAuthnErr = webauthn.New(&webauthn.Config{
+			RPID:          cfg.WebAuthnRPID,
+			RPDisplayName: cfg.WebAuthnRPName,
+			RPOrigins:     cfg.WebAuthnOrigins,
+		})
+	})
+	return webAuthnInstance, webAuthnErr
+}
+
+// GetPasskeys lists the passkeys registered to a user
+func GetPasskeys(userID int) ([]models.DbWebAuthnCredential, error) {
+	var rows []models.DbWebAuthnCredential
+	if err := database.DB.Select(&rows, models.WebAuthnQueries.GetByUserID, userID); err != nil {
+		return nil, fmt.Errorf("failed to get passkeys: %v", err)
+	}
+	return rows, nil
+}
+
+// DeletePasskey removes one of the user's passkeys
+func DeletePasskey(userID, credentialID int) error {
+	result, err := database.DB.Exec(models.WebAuthnQueries.Delete, credentialID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete passkey: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// BeginPasskeyRegistration starts registering a new authenticator for a
+// signed-in user. Authenticators already on the account are excluded.
+func BeginPasskeyRegistration(user *models.DbUser) (string, *protocol.CredentialCreation, error) {
+	wa, err := getWebAuthn()
+	if err != nil {
+		return "", nil, fmt.Errorf("webauthn is not configured: %v", err)
+	}
+
+	pu, err := loadPasskeyUser(user)
+	if err != nil {
+		return "", nil, err
+	}
+
+	creation, session, err := wa.BeginRegistration(pu,
+		webauthn.WithExclusions(webauthn.Credentials(pu.credentials).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to begin registration: %v", err)
+	}
+
+	sessionID, err := passkeySessions.Put(*session)
+	if err != nil {
+		return "", nil, err
+	}
+	return sessionID, creation, nil
+}
+
+// FinishPasskeyRegistration verifies the authenticator's attestation response
+// in r and stores the new credential under the given name.
+func FinishPasskeyRegistration(user *models.DbUser, sessionID, name string, r *http.Request) (*models.DbWebAuthnCredential, error) {
+	wa, err := getWebAuthn()
+	if err != nil {
+		return nil, fmt.Errorf("webauthn is not configured: %v", err)
+	}
+
+	session, ok := passkeySessions.Take(sessionID)
+	if !ok || string(session.UserID) != strconv.Itoa(user.ID) {
+		return nil, ErrInvalidPasskeySession
+	}
+
+	pu, err := loadPasskeyUser(user)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := wa.FinishRegistration(pu, session, r)
+	if err != nil {
+		log.Printf("Passkey registration failed for user %d: %v", user.ID, err)
+		return nil, ErrPasskeyFailed
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode passkey: %v", err)
+	}
+
+	if name == "" {
+		name = "Passkey"
+	}
+	row := models.DbWebAuthnCredential{
+		UserID:       user.ID,
+		CredentialID: base64.RawURLEncoding.EncodeToString(credential.ID),
+		Name:         name,
+	}
+	err = database.DB.QueryRowx(models.WebAuthnQueries.Insert, row.UserID, row.CredentialID, row.Name, string(data)).
+		Scan(&row.ID, &row.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store passkey: %v", err)
+	}
+
+	return &row, nil
+}
+
+// BeginPasskeyLogin starts an assertion ceremony. With a username the
+// options list that user's credentials; without one the browser offers any
+// discoverable passkey for this site.
+func BeginPasskeyLogin(userName string) (string, *protocol.CredentialAssertion, error) {
+	wa, err := getWebAuthn()
+	if err != nil {
+		return "", nil, fmt.Errorf("webauthn is not configured: %v", err)
+	}
+
+	var assertion *protocol.CredentialAssertion
+	var session *webauthn.SessionData
+	if userName == "" {
+		assertion, session, err = wa.BeginDiscoverableLogin(passkeyLoginOptions...)
+	} else {
+		user, lookupErr := GetUserByUsername(userName)
+		if lookupErr != nil {
+			return "", nil, ErrPasskeyUnavailable
+		}
+		pu, loadErr := loadPasskeyUser(user)
+		if loadErr != nil {
+			return "", nil, loadErr
+		}
+		if len(pu.credentials) == 0 {
+			return "", nil, ErrPasskeyUnavailable
+		}
+		assertion, session, err = wa.BeginLogin(pu, passkeyLoginOptions...)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to begin passkey login: %v", err)
+	}
+
+	sessionID, err := passkeySessions.Put(*session)
+	if err != nil {
+		return "", nil, err
+	}
+	return sessionID, assertion, nil
+}
+
+// FinishPasskeyLogin verifies the assertion in r and returns the signed-in user
+func FinishPasskeyLogin(sessionID string, r *http.Request) (*models.DbUser, error) {
+	wa, err := getWebAuthn()
+	if err != nil {
+		return nil, fmt.Errorf("webauthn is not configured: %v", err)
+	}
+
+	session, ok := passkeySessions.Take(sessionID)
+	if !ok {
+		return nil, ErrInvalidPasskeySession
+	}
+
+	var pu *passkeyUser
+	var credential *webauthn.Credential
+	if len(session.UserID) == 0 {
+		var found webauthn.User
+		found, credential, err = wa.FinishPasskeyLogin(discoverPasskeyUser, session, r)
+		if err == nil {
+			pu = found.(*passkeyUser)
+		}
+	} else {
+		pu, err = loadPasskeyUserByHandle(session.UserID)
+		if err == nil {
+			credential, err = wa.FinishLogin(pu, session, r)
+		}
+	}
+	if err != nil {
+		log.Printf("Passkey login failed: %v", err)
+		return nil, ErrPasskeyFailed
+	}
+
+	if credential.Authenticator.CloneWarning {
+		log.Printf("Passkey clone warning for user %d", pu.user.ID)
+		return nil, ErrPasskeyFailed
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode passkey: %v", err)
+	}
+	credentialID := base64.RawURLEncoding.EncodeToString(credential.ID)
+	if _, err := database.DB.Exec(models.WebAuthnQueries.UpdateData, string(data), credentialID); err != nil {
+		return nil, fmt.Errorf("failed to update passkey: %v", err)
+	}
+
+	return pu.user, nil
+}
+
+func discoverPasskeyUser(rawID, userHandle []byte) (webauthn.User, error) {
+	return loadPasskeyUserByHandle(userHandle)
+}
+
+func loadPasskeyUserByHandle(handle []byte) (*passkeyUser, error) {
+	userID, err := strconv.Atoi(string(handle))
+	if err != nil {
+		return nil, ErrPasskeyFailed
+	}
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, ErrPasskeyFailed
+	}
+	return loadPasskeyUser(user)
+}
+
+func loadPasskeyUser(user *models.DbUser) (*passkeyUser, error) {
+	rows, err := GetPasskeys(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	pu := &passkeyUser{user: user}
+	for _, row := range rows {
+		var credential webauthn.Credential
+		if err := json.Unmarshal([]byte(row.CredentialData), &credential); err != nil {
+			log.Printf("Skipping unreadable passkey %d: %v", row.ID, err)
+			continue
+		}
+		pu.credentials = append(pu.credentials, credential)
+	}
+	return pu, nil
+}
@@ -0,0 +1,309 @@
+package services
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"goserver/internal/models"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:3000"
+)
+
+// virtualAuthenticator is a software passkey: a P-256 key with "none"
+// attestation that answers the browser side of both ceremonies.
+type virtualAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+	// presenceOnly answers without user verification, like a basic security key
+	presenceOnly bool
+}
+
+func newVirtualAuthenticator(t *testing.T) *virtualAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+	return &virtualAuthenticator{key: key, credentialID: id}
+}
+
+func (a *virtualAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append([]byte(nil), rpIDHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func clientData(t *testing.T, ceremony string, challenge protocol.URLEncodedBase64) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// create answers navigator.credentials.create with an attestation response
+func (a *virtualAuthenticator) create(t *testing.T, options *protocol.CredentialCreation) []byte {
+	t.Helper()
+	a.userHandle = options.Response.User.ID.(protocol.URLEncodedBase64)
+
+	// COSE_Key for ES256 on P-256
+	publicKey, err := webauthncbor.Marshal(map[int]any{
+		1:  int(webauthncose.EllipticKey),
+		3:  int(webauthncose.AlgES256),
+		-1: 1,
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, publicKey...)
+
+	flags := byte(protocol.FlagUserPresent | protocol.FlagUserVerified | protocol.FlagAttestedCredentialData)
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(flags, attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.marshalCredential(t, map[string]any{
+		"clientDataJSON":    clientData(t, "webauthn.create", options.Response.Challenge),
+		"attestationObject": attestation,
+	})
+}
+
+// get answers navigator.credentials.get with a signed assertion
+func (a *virtualAuthenticator) get(t *testing.T, options *protocol.CredentialAssertion) []byte {
+	t.Helper()
+	a.signCount++
+
+	flags := protocol.FlagUserPresent | protocol.FlagUserVerified
+	if a.presenceOnly {
+		flags = protocol.FlagUserPresent
+	}
+	authData := a.authData(byte(flags), nil)
+	clientDataJSON := clientData(t, "webauthn.get", options.Response.Challenge)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.marshalCredential(t, map[string]any{
+		"clientDataJSON":    clientDataJSON,
+		"authenticatorData": authData,
+		"signature":         signature,
+		"userHandle":        a.userHandle,
+	})
+}
+
+func (a *virtualAuthenticator) marshalCredential(t *testing.T, response map[string]any) []byte {
+	t.Helper()
+	encoded := map[string]string{}
+	for k, v := range response {
+		encoded[k] = base64.RawURLEncoding.EncodeToString(v.([]byte))
+	}
+	id := base64.RawURLEncoding.EncodeToString(a.credentialID)
+	body, err := json.Marshal(map[string]any{
+		"id":       id,
+		"rawId":    id,
+		"type":     "public-key",
+		"response": encoded,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func ceremonyRequest(body []byte) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func testWebAuthn(t *testing.T) *webauthn.WebAuthn {
+	t.Helper()
+	t.Setenv("WEBAUTHN_RP_ID", testRPID)
+	t.Setenv("WEBAUTHN_ORIGINS", testOrigin)
+	webAuthnOnce = sync.Once{}
+	t.Cleanup(func() { webAuthnOnce = sync.Once{} })
+
+	wa, err := getWebAuthn()
+	if err != nil {
+		t.Fatalf("getWebAuthn: %v", err)
+	}
+	return wa
+}
+
+// TestPasskeyRegisterThenLogin runs both ceremonies the way the service
+// does, including the JSON round trip through webauthn_credentials, with
+// discoverable login as the browser would use it.
+func TestPasskeyRegisterThenLogin(t *testing.T) {
+	wa := testWebAuthn(t)
+	authenticator := newVirtualAuthenticator(t)
+	pu := &passkeyUser{user: &models.DbUser{ID: 7, Username: "linda"}}
+
+	creation, session, err := wa.BeginRegistration(pu,
+		webauthn.WithExclusions(webauthn.Credentials(pu.credentials).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	sessionID, err := passkeySessions.Put(*session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, ok := passkeySessions.Take(sessionID)
+	if !ok || string(stored.UserID) != "7" {
+		t.Fatalf("registration session not usable for user 7: %+v", stored)
+	}
+	credential, err := wa.FinishRegistration(pu, stored, ceremonyRequest(authenticator.create(t, creation)))
+	if err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	if !bytes.Equal(credential.ID, authenticator.credentialID) {
+		t.Fatalf("registered credential %x, want %x", credential.ID, authenticator.credentialID)
+	}
+
+	// Stored and loaded as loadPasskeyUser does
+	data, err := json.Marshal(credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded webauthn.Credential
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	pu.credentials = []webauthn.Credential{loaded}
+
+	assertion, session, err := wa.BeginDiscoverableLogin(passkeyLoginOptions...)
+	if err != nil {
+		t.Fatalf("BeginDiscoverableLogin: %v", err)
+	}
+	discover := func(rawID, userHandle []byte) (webauthn.User, error) {
+		if string(userHandle) != "7" {
+			t.Errorf("user handle %q, want 7", userHandle)
+		}
+		return pu, nil
+	}
+
+	found, used, err := wa.FinishPasskeyLogin(discover, *session, ceremonyRequest(authenticator.get(t, assertion)))
+	if err != nil {
+		t.Fatalf("FinishPasskeyLogin: %v", err)
+	}
+	if found.(*passkeyUser).user.ID != 7 {
+		t.Errorf("signed in as %d, want 7", found.(*passkeyUser).user.ID)
+	}
+	if used.Authenticator.CloneWarning || used.Authenticator.SignCount != 1 {
+		t.Errorf("unexpected authenticator state %+v", used.Authenticator)
+	}
+
+	// The same assertion can't be replayed against a new challenge
+	_, session, err = wa.BeginDiscoverableLogin(passkeyLoginOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay := authenticator.get(t, assertion)
+	if _, _, err := wa.FinishPasskeyLogin(discover, *session, ceremonyRequest(replay)); err == nil {
+		t.Error("assertion for an old challenge was accepted")
+	}
+}
+
+// registerPasskey registers authenticator for pu and adds the credential
+func registerPasskey(t *testing.T, wa *webauthn.WebAuthn, pu *passkeyUser, authenticator *virtualAuthenticator) {
+	t.Helper()
+	creation, session, err := wa.BeginRegistration(pu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := wa.FinishRegistration(pu, *session, ceremonyRequest(authenticator.create(t, creation)))
+	if err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	pu.credentials = append(pu.credentials, *credential)
+}
+
+// TestPasskeyLoginRequiresUserVerification checks a passkey only signs in
+// when the authenticator verified the user, since it replaces the MFA step.
+func TestPasskeyLoginRequiresUserVerification(t *testing.T) {
+	wa := testWebAuthn(t)
+	authenticator := newVirtualAuthenticator(t)
+	pu := &passkeyUser{user: &models.DbUser{ID: 7, Username: "linda"}}
+	registerPasskey(t, wa, pu, authenticator)
+
+	assertion, session, err := wa.BeginLogin(pu, passkeyLoginOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assertion.Response.UserVerification != protocol.VerificationRequired {
+		t.Errorf("options ask for user verification %q, want required", assertion.Response.UserVerification)
+	}
+
+	authenticator.presenceOnly = true
+	if _, err := wa.FinishLogin(pu, *session, ceremonyRequest(authenticator.get(t, assertion))); err == nil {
+		t.Error("assertion without user verification was accepted")
+	}
+}
+
+// TestPasskeyLoginRejectsOtherKey checks a credential ID backed by the wrong
+// key does not sign in, as with a cloned or forged authenticator.
+func TestPasskeyLoginRejectsOtherKey(t *testing.T) {
+	wa := testWebAuthn(t)
+	authenticator := newVirtualAuthenticator(t)
+	pu := &passkeyUser{user: &models.DbUser{ID: 7, Username: "linda"}}
+
+	registerPasskey(t, wa, pu, authenticator)
+
+	impostor := newVirtualAuthenticator(t)
+	impostor.credentialID = authenticator.credentialID
+	impostor.userHandle = authenticator.userHandle
+
+	assertion, session, err := wa.BeginLogin(pu, passkeyLoginOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wa.FinishLogin(pu, *session, ceremonyRequest(impostor.get(t, assertion))); err == nil {
+		t.Error("assertion signed by another key was accepted")
+	}
+}
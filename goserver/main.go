@@ -7,6 +7,7 @@ import (
 	"goserver/internal/config"
 	"goserver/internal/database"
 	"goserver/internal/router"
+	"goserver/internal/services"
 
 	"github.com/joho/godotenv"
 )
@@ -25,6 +26,17 @@ func main() {
 		log.Println("No .env file found or error loading .env file")
 	}
 	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+
+	if err := services.LoadSigningKeys(); err != nil {
+		log.Fatal("Failed to load JWT signing keys:", err)
+	}
+
+	if err := services.LoadBreachedPasswords(); err != nil {
+		log.Fatal("Failed to load breached password list:", err)
+	}
 
 	// Connect to PostgreSQL database
 	if err := database.ConnectDatabase(); err != nil {
@@ -34,6 +46,20 @@ func main() {
 	}
 	defer database.CloseDatabase()
 
+	if err := database.EnsureSchema(); err != nil {
+		log.Fatal("Failed to prepare database schema:", err)
+	}
+
+	if err := services.SeedRoles(); err != nil {
+		log.Fatal("Failed to load roles:", err)
+	}
+
+	if err := services.LoadRevocations(); err != nil {
+		log.Fatal("Failed to load token revocations:", err)
+	}
+
+	services.StartBlogScheduler(cfg.BlogSchedulerTick)
+
 	r := router.SetupRouter()
 	r.SetTrustedProxies([]string{"127.0.0.1"})
 